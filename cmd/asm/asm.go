@@ -2,7 +2,8 @@ package main
 
 import (
 	"bufio"
-	"encoding/binary"
+	"fmt"
+	"io"
 	"os"
 
 	"github.com/aveplen/sm/internal"
@@ -10,29 +11,86 @@ import (
 )
 
 var opts struct {
-	Input   string `short:"i" long:"input" description:"Input file"`
-	Output  string `short:"o" long:"output" description:"Output file"`
-	Verbose bool   `short:"v" long:"verbose" description:"Print list of tokens after compilation"`
+	Input   []string `short:"i" long:"input" description:"Input file, may be repeated or given as positional arguments"`
+	Output  string   `short:"o" long:"output" description:"Output file"`
+	Verbose bool     `short:"v" long:"verbose" description:"Print list of tokens after compilation"`
+	Include []string `short:"I" long:"include-dir" description:"Directory to search for .include files, may be repeated"`
+
+	WordWidth int    `long:"word-width" default:"16" choice:"16" choice:"32" choice:"64" description:"Machine word width in bits"`
+	Entry     string `long:"entry" description:"Label to start execution at (program start by default)"`
+	Raw       bool   `long:"raw" description:"Write bare program words without image header, data and symbols"`
+	Object    bool   `short:"c" long:"object" description:"Write a relocatable object to be linked with ld instead of an image"`
+	Listing   string `long:"listing" description:"Write source lines with addresses and assembled words to this file"`
+	Map       string `long:"map" description:"Write symbols with their addresses to this file"`
+
+	LegacyLabels bool `long:"legacy-labels" description:"Emit a NOP word for every label, as older versions did"`
 }
 
 func main() {
-	if _, err := flags.ParseArgs(&opts, os.Args); err != nil {
+	args, err := flags.ParseArgs(&opts, os.Args)
+	if err != nil {
 		return
 	}
 
-	// input file reader
-	fin, err := os.Open(opts.Input)
-	if err != nil {
-		panic(err)
+	// input files are assembled one after another
+	inputs := append(opts.Input, args[1:]...)
+	if len(inputs) == 0 {
+		fmt.Fprintln(os.Stderr, "asm: no input files")
+		os.Exit(1)
 	}
 
-	defer func() {
-		if err := fin.Close(); err != nil {
+	asmopts := internal.Options{
+		WordWidth:   opts.WordWidth,
+		Entry:       opts.Entry,
+		Verbose:     opts.Verbose,
+		IncludeDirs: opts.Include,
+
+		LegacyLabels: opts.LegacyLabels,
+	}
+
+	if opts.Object {
+		if opts.Raw || opts.Entry != "" || opts.Listing != "" || opts.Map != "" {
+			fmt.Fprintln(os.Stderr, "asm: --raw, --entry, --listing and --map are not supported for objects")
+			os.Exit(1)
+		}
+		obj, err := internal.AssembleObject(inputs, asmopts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		err = internal.WriteFile(opts.Output, func(w io.Writer) error {
+			return internal.WriteObject(w, obj)
+		})
+		if err != nil {
 			panic(err)
 		}
-	}()
+		return
+	}
 
-	finr := bufio.NewReader(fin)
+	// main compiler call, output is not touched
+	// if source has errors
+	img, err := internal.AssembleFiles(inputs, asmopts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if opts.Listing != "" {
+		err = internal.WriteFile(opts.Listing, func(w io.Writer) error {
+			return internal.WriteListing(w, img)
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+	if opts.Map != "" {
+		err = internal.WriteFile(opts.Map, func(w io.Writer) error {
+			return internal.WriteMap(w, img)
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
 
 	// output file reader
 	fout, err := os.Create(opts.Output)
@@ -53,13 +111,17 @@ func main() {
 		}
 	}()
 
-	// main compiler call
-	program, err := internal.Compile(*finr, opts.Verbose)
-	if err != nil {
-		panic(err)
+	if opts.Raw {
+		if len(img.Data) != 0 {
+			fmt.Fprintf(os.Stderr, "asm: warning: raw output drops %d data words\n", len(img.Data))
+		}
+		if err := internal.WriteWords(foutw, img.Code, img.WordWidth); err != nil {
+			panic(err)
+		}
+		return
 	}
 
-	if err := binary.Write(foutw, binary.LittleEndian, program); err != nil {
+	if err := internal.WriteImage(foutw, img); err != nil {
 		panic(err)
 	}
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/aveplen/sm/internal"
+	"github.com/jessevdk/go-flags"
+)
+
+var opts struct {
+	Input     string `short:"i" long:"input" description:"Input file, image or raw program words"`
+	Output    string `short:"o" long:"output" description:"Output file (stdout by default)"`
+	WordWidth int    `long:"word-width" default:"16" choice:"16" choice:"32" choice:"64" description:"Machine word width in bits of raw program files, images carry their own"`
+}
+
+func main() {
+	if _, err := flags.ParseArgs(&opts, os.Args); err != nil {
+		return
+	}
+
+	raw, err := os.ReadFile(opts.Input)
+	if err != nil {
+		panic(err)
+	}
+
+	img, err := internal.LoadImage(raw, opts.WordWidth)
+	if err != nil {
+		panic(err)
+	}
+
+	fout := os.Stdout
+	if opts.Output != "" {
+		fout, err = os.Create(opts.Output)
+		if err != nil {
+			panic(err)
+		}
+
+		defer func() {
+			if err := fout.Close(); err != nil {
+				panic(err)
+			}
+		}()
+	}
+
+	foutw := bufio.NewWriter(fout)
+	defer func() {
+		if err := foutw.Flush(); err != nil {
+			panic(err)
+		}
+	}()
+
+	if _, err := foutw.WriteString(internal.DisassembleImage(img)); err != nil {
+		panic(err)
+	}
+}
@@ -3,97 +3,138 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"math/rand"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/aveplen/sm/internal"
+	"github.com/jessevdk/go-flags"
 )
 
 const matrixSize = 10
 
+var opts struct {
+	MaxSteps int           `long:"max-steps" default:"10000" description:"Stop every worker after this many instructions (0 for no limit)"`
+	Timeout  time.Duration `long:"timeout" default:"5s" description:"Stop all workers after this much time (0 for no limit)"`
+
+	ProgramWords int `long:"program-words" default:"80" description:"Size of program memory of every worker in words"`
+	DataWords    int `long:"data-words" default:"80" description:"Size of data memory of every worker in words"`
+	StackDepth   int `long:"stack-depth" default:"16" description:"Size of the stack of every worker in words"`
+	ReturnDepth  int `long:"return-depth" default:"16" description:"Size of the return stack of every worker in words"`
+	WordWidth    int `long:"word-width" default:"16" choice:"16" choice:"32" choice:"64" description:"Machine word width of every worker in bits"`
+}
+
 const sourceCode = `
 /* 00 */   start:             //
-/* 01 */     push             // load arr len
-/* 02 */     0                //
-/* 03 */     load             //
+/* 00 */     push             // load arr len
+/* 01 */     0                //
+/* 02 */     load             //
                               //
-/* 04 */     dup              // save first length into arr_len1
-/* 05 */     push             //
-/* 06 */     0                // arr len
-/* 07 */     stor             //
+/* 03 */     dup              // save first length into arr_len1
+/* 04 */     push             //
+/* 05 */     0                // arr len
+/* 06 */     stor             //
                               //
-/* 08 */     dup              // goto final_routine if arr len == 0
-/* 09 */     push             //
-/* 10 */     &final_routine   //
-/* 11 */     swap             //
-/* 12 */     jz               //
+/* 07 */     dup              // goto final_routine if arr len == 0
+/* 08 */     push             //
+/* 09 */     &final_routine   //
+/* 10 */     swap             //
+/* 11 */     jz               //
                               //
-/* 13 */     stc              // counter = arr len
+/* 12 */     stc              // counter = arr len
                               //
-/* 14 */   mult_routine:      //
-/* 15 */     cts              // get counter
-/* 16 */     load             // load element of the first array (counter)
+/* 13 */   mult_routine:      //
+/* 13 */     cts              // get counter
+/* 14 */     load             // load element of the first array (counter)
                               //
-/* 17 */     cts              // get counter
-/* 18 */     push             //
-/* 19 */     0                // push arr len addr
-/* 20 */     load             // load arr len
-/* 21 */     push             //
-/* 22 */     1                //
-/* 23 */     add              //
-/* 24 */     add              //
-/* 25 */     load             // load element of the second array (counter + len1 + 1)
+/* 15 */     cts              // get counter
+/* 16 */     push             //
+/* 17 */     0                // push arr len addr
+/* 18 */     load             // load arr len
+/* 19 */     push             //
+/* 20 */     1                //
+/* 21 */     add              //
+/* 22 */     add              //
+/* 23 */     load             // load element of the second array (counter + len1 + 1)
                               // additional 1 is for arr2 len, which is not used in program
                               //
-/* 26 */     mul              // arr1[i] * arr2[i]
+/* 24 */     mul              // arr1[i] * arr2[i]
                               //
-/* 27 */     cdec             // counter --
+/* 25 */     cdec             // counter --
                               //
-/* 28 */     cts              // jump to sum_routine if counter == 0
-/* 29 */     push             //
-/* 30 */     &sum_routine     //
-/* 31 */     swap             //
-/* 32 */     jz               //
+/* 26 */     cts              // jump to sum_routine if counter == 0
+/* 27 */     push             //
+/* 28 */     &sum_routine     //
+/* 29 */     swap             //
+/* 30 */     jz               //
                               //
-/* 33 */     push             // if counter != 0 continue multiplying on a stack
-/* 34 */     &mult_routine    //
-/* 35 */     jmp              //
+/* 31 */     push             // if counter != 0 continue multiplying on a stack
+/* 32 */     &mult_routine    //
+/* 33 */     jmp              //
                               //
-/* 36 */   sum_routine:       //
-/* 37 */     push             // counter = len(arr)-1
-/* 38 */     0                // arr len
-/* 39 */     load             //
-/* 40 */     stc              //
-/* 41 */     cdec             //
+/* 34 */   sum_routine:       //
+/* 34 */     push             // counter = len(arr)-1
+/* 35 */     0                // arr len
+/* 36 */     load             //
+/* 37 */     stc              //
+/* 38 */     cdec             //
                               //
-/* 42 */   while:             //
-/* 43 */     add              // arr1[i-1]*arr2[i-1] + arr1[i]*arr2[i]
-/* 44 */     cdec             // counter --
+/* 39 */   while:             //
+/* 39 */     add              // arr1[i-1]*arr2[i-1] + arr1[i]*arr2[i]
+/* 40 */     cdec             // counter --
                               //
-/* 45 */     cts              // if counter == 0 goto final_routine
-/* 46 */     push             //
-/* 47 */     &final_routine   //
-/* 48 */     swap             //
-/* 49 */     jz               //
+/* 41 */     cts              // if counter == 0 goto final_routine
+/* 42 */     push             //
+/* 43 */     &final_routine   //
+/* 44 */     swap             //
+/* 45 */     jz               //
                               //
-/* 50 */     push             // goto while
-/* 51 */     &while           //
-/* 52 */     jmp              //
+/* 46 */     push             // goto while
+/* 47 */     &while           //
+/* 48 */     jmp              //
                               //
-/* 53 */   final_routine:     //
-/* 54 */     term             //
+/* 49 */   final_routine:     //
+/* 49 */     outnum           //
+/* 50 */     term             //
 `
 
-var program = func() []uint16 {
+func compile(width int) ([]uint64, error) {
 	buffer := make([]byte, len(sourceCode))
 	copy(buffer, []byte(sourceCode))
 	reader := bytes.NewReader(buffer)
-	prog, _ := internal.Compile(*bufio.NewReader(reader), false)
-	return prog
-}()
+	return internal.CompileWith(*bufio.NewReader(reader), internal.Options{WordWidth: width})
+}
 
 func main() {
+	if _, err := flags.ParseArgs(&opts, os.Args); err != nil {
+		return
+	}
+
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	cfg := internal.DefaultConfig()
+	cfg.ProgramWords = opts.ProgramWords
+	cfg.DataWords = opts.DataWords
+	cfg.StackDepth = opts.StackDepth
+	cfg.ReturnDepth = opts.ReturnDepth
+	cfg.WordWidth = opts.WordWidth
+
+	program, err := compile(opts.WordWidth)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gpu: %v\n", err)
+		os.Exit(1)
+	}
+
 	matr1 := generateMatrix(matrixSize)
 	outputMatrix(matr1)
 
@@ -109,25 +150,42 @@ func main() {
 	for i := 0; i < matrixSize; i++ {
 		for j := 0; j < matrixSize; j++ {
 
-			data := make([]uint16, (matrixSize+1)*2)
+			data := make([]uint64, (matrixSize+1)*2)
 
 			data[0] = matrixSize
 			for k := 0; k < matrixSize; k++ {
-				data[k+1] = uint16(matr1[i][k])
+				data[k+1] = uint64(matr1[i][k])
 			}
 
-			data[matrixSize] = matrixSize
+			data[matrixSize+1] = matrixSize
 			for k := 0; k < matrixSize; k++ {
-				data[matrixSize+k+1] = uint16(matr2[k][j])
+				data[matrixSize+k+2] = uint64(matr2[k][j])
 			}
 
 			wg.Add(1)
-			go func(program, data []uint16, i, j int) {
-				wg.Done()
-
-				cpu := internal.WithMemProg(program, data)
-				cpu.Run()
-				res[i][j] = int(cpu.StackDump()[0])
+			go func(program, data []uint64, i, j int) {
+				defer wg.Done()
+
+				cpu, err := internal.WithConfig(cfg, program, data)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "gpu: cell (%d, %d): %v\n", i, j, err)
+					return
+				}
+
+				out := bytes.Buffer{}
+				cpu.SetIO(bytes.NewReader(nil), &out)
+
+				if _, err := cpu.RunContext(ctx, opts.MaxSteps); err != nil {
+					fmt.Fprintf(os.Stderr, "gpu: cell (%d, %d): %v\n", i, j, err)
+					return
+				}
+
+				val, err := strconv.Atoi(strings.TrimSpace(out.String()))
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "gpu: cell (%d, %d): %v\n", i, j, err)
+					return
+				}
+				res[i][j] = val
 			}(program, data, i, j)
 		}
 	}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aveplen/sm/internal"
+	"github.com/jessevdk/go-flags"
+)
+
+var opts struct {
+	Input  []string `short:"i" long:"input" description:"Object file, may be repeated or given as positional arguments"`
+	Output string   `short:"o" long:"output" description:"Output image file"`
+	Entry  string   `long:"entry" description:"Global label to start execution at (start of the first object by default)"`
+	Map    string   `long:"map" description:"Write symbols with their addresses to this file"`
+}
+
+func main() {
+	args, err := flags.ParseArgs(&opts, os.Args)
+	if err != nil {
+		return
+	}
+
+	// objects are laid out in the order they are given
+	inputs := append(opts.Input, args[1:]...)
+	if len(inputs) == 0 {
+		fmt.Fprintln(os.Stderr, "ld: no input files")
+		os.Exit(1)
+	}
+
+	objs := make([]*internal.Object, 0, len(inputs))
+	for _, name := range inputs {
+		obj, err := readobject(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ld: %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		objs = append(objs, obj)
+	}
+
+	img, err := internal.Link(objs, opts.Entry)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if opts.Map != "" {
+		err = internal.WriteFile(opts.Map, func(w io.Writer) error {
+			return internal.WriteMap(w, img)
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+	err = internal.WriteFile(opts.Output, func(w io.Writer) error {
+		return internal.WriteImage(w, img)
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func readobject(name string) (*internal.Object, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	obj, err := internal.ReadObject(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	obj.Name = name
+	return obj, nil
+}
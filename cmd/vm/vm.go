@@ -1,18 +1,35 @@
 package main
 
 import (
-	"encoding/binary"
+	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aveplen/sm/internal"
 	"github.com/jessevdk/go-flags"
 )
 
 var opts struct {
-	Input   string `short:"i" long:"input" description:"Input file name"`
-	Verbose bool   `short:"v" long:"verbose" description:"Dump machine state on every instruction"`
+	Input    string        `short:"i" long:"input" description:"Input file name"`
+	Verbose  bool          `short:"v" long:"verbose" description:"Dump machine state after the program stops"`
+	MaxSteps int           `long:"max-steps" description:"Stop after this many instructions (0 for no limit)"`
+	Timeout  time.Duration `long:"timeout" description:"Stop after this much time, e.g. 500ms or 2s (0 for no limit)"`
+	Trace    string        `long:"trace" description:"Write every executed instruction to this file as JSON lines"`
+	Save     string        `long:"save" description:"Write machine state to this file when the program stops"`
+	Resume   string        `long:"resume" description:"Continue from machine state saved with --save instead of loading a program"`
+	Stdin    string        `long:"stdin" description:"File read by IN instead of standard input, in the debugger IN reads nothing without it"`
+
+	ProgramWords int `long:"program-words" default:"80" description:"Size of program memory in words"`
+	DataWords    int `long:"data-words" default:"80" description:"Size of data memory in words"`
+	StackDepth   int `long:"stack-depth" default:"16" description:"Size of the stack in words"`
+	ReturnDepth  int `long:"return-depth" default:"16" description:"Size of the return stack in words"`
+	WordWidth    int `long:"word-width" default:"16" choice:"16" choice:"32" choice:"64" description:"Machine word width in bits of raw program files, images carry their own"`
+
+	Signed bool `long:"signed" description:"Signed arithmetic mode: DIV, MOD and OUTNUM use two's complement, data may be negative"`
 }
 
 func main() {
@@ -21,42 +38,195 @@ func main() {
 		return
 	}
 
-	// input file
-	fin, err := os.Open(opts.Input)
+	// `vm debug prog.compiled` runs the program in the debugger,
+	// the program may be given as the first positional argument
+	debug := len(args) > 1 && args[1] == "debug"
+	if debug {
+		args = args[1:]
+		if opts.Input == "" && len(args) > 1 {
+			opts.Input = args[1]
+			args = args[1:]
+		}
+	}
+
+	var img *internal.Image
+	cpu := internal.NewCpu()
+	if opts.Resume != "" {
+		if len(args) > 1 {
+			fmt.Fprintf(os.Stderr, "vm: data can not be given with --resume\n")
+			os.Exit(1)
+		}
+
+		// geometry flags are ignored, the saved state carries
+		// its own, and the debugger gets no symbols
+		snap := readsnapshot(opts.Resume)
+		err = cpu.Restore(snap)
+		img = &internal.Image{
+			ISAVersion: snap.ISAVersion,
+			WordWidth:  snap.Config.WordWidth,
+			Code:       snap.Program,
+		}
+	} else {
+		img = loadimage(opts.Input, args[1:])
+		cpu, err = internal.FromImage(config(), img)
+	}
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "vm: %v\n", err)
+		os.Exit(1)
 	}
-	defer func() {
-		if err := fin.Close(); err != nil {
-			panic(err)
+
+	// the debugger reads commands from standard input,
+	// so the program gets its own or none at all
+	if opts.Stdin != "" {
+		f, err := os.Open(opts.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "vm: %v\n", err)
+			os.Exit(1)
 		}
-	}()
+		defer f.Close()
+		cpu.SetIO(f, os.Stdout)
+	} else if debug {
+		cpu.SetIO(strings.NewReader(""), os.Stdout)
+	}
+
+	// trace is closed explicitly, as deferred
+	// calls do not run after os.Exit
+	closetrace := func() {}
+	if opts.Trace != "" {
+		var tracer internal.Tracer
+		tracer, closetrace = opentrace(opts.Trace)
+		cpu.SetTracer(tracer)
+	}
 
-	stat, err := fin.Stat()
+	if debug {
+		defer closetrace()
+		if err := internal.NewDebugger(cpu, img).Repl(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "vm: %v\n", err)
+			os.Exit(1)
+		}
+		if opts.Save != "" {
+			writesnapshot(opts.Save, cpu.Snapshot())
+		}
+		return
+	}
+
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	steps, runerr := cpu.RunContext(ctx, opts.MaxSteps)
+	closetrace()
+	if opts.Save != "" {
+		writesnapshot(opts.Save, cpu.Snapshot())
+	}
+
+	if opts.Verbose {
+		fmt.Println(cpu.Dump())
+	}
+
+	if runerr != nil {
+		fmt.Fprintf(os.Stderr, "vm: %v (after %d steps)\n", runerr, steps)
+		os.Exit(1)
+	}
+}
+
+// loadimage reads an image or raw program words, positional
+// arguments overwrite initial data from address 0.
+func loadimage(name string, args []string) *internal.Image {
+	raw, err := os.ReadFile(name)
 	if err != nil {
 		panic(err)
 	}
 
-	// program read
-	program := make([]uint16, stat.Size()/2)
-	if err := binary.Read(fin, binary.LittleEndian, program); err != nil {
+	img, err := internal.LoadImage(raw, opts.WordWidth)
+	if err != nil {
 		panic(err)
 	}
 
-	data := make([]uint16, 0, 10)
-	for _, v := range args[1:] {
-		uintmem, err := strconv.ParseUint(v, 10, 16)
+	for i, v := range args {
+		uintmem, err := parseword(v, img.WordWidth)
 		if err != nil {
 			panic(err)
 		}
-		data = append(data, uint16(uintmem))
+		if i < len(img.Data) {
+			img.Data[i] = uintmem
+		} else {
+			img.Data = append(img.Data, uintmem)
+		}
 	}
+	return img
+}
 
-	cpu := internal.WithMemProg(program, data)
+// parseword parses a data word, negative
+// numbers are allowed in signed mode.
+func parseword(v string, width int) (uint64, error) {
+	if !opts.Signed {
+		return strconv.ParseUint(v, 10, width)
+	}
+	num, err := strconv.ParseInt(v, 10, width)
+	return uint64(num) & (1<<uint(width) - 1), err
+}
 
-	cpu.Run()
+func config() internal.Config {
+	cfg := internal.DefaultConfig()
+	cfg.ProgramWords = opts.ProgramWords
+	cfg.DataWords = opts.DataWords
+	cfg.StackDepth = opts.StackDepth
+	cfg.ReturnDepth = opts.ReturnDepth
+	cfg.Signed = opts.Signed
+	return cfg
+}
 
-	if opts.Verbose {
-		fmt.Println(cpu.Dump())
+func readsnapshot(name string) *internal.Snapshot {
+	f, err := os.Open(name)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	snap, err := internal.ReadSnapshot(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vm: %v\n", err)
+		os.Exit(1)
+	}
+	return snap
+}
+
+func writesnapshot(name string, snap *internal.Snapshot) {
+	f, err := os.Create(name)
+	if err != nil {
+		panic(err)
+	}
+	if err := internal.WriteSnapshot(f, snap); err != nil {
+		panic(err)
+	}
+	if err := f.Close(); err != nil {
+		panic(err)
+	}
+}
+
+// opentrace creates a JSON lines tracer writing to the
+// file and a function that completes the file.
+func opentrace(name string) (internal.Tracer, func()) {
+	f, err := os.Create(name)
+	if err != nil {
+		panic(err)
+	}
+	w := bufio.NewWriter(f)
+	tracer := internal.NewJSONTracer(w)
+
+	return tracer, func() {
+		if err := tracer.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "vm: %v\n", err)
+		}
+		if err := w.Flush(); err != nil {
+			panic(err)
+		}
+		if err := f.Close(); err != nil {
+			panic(err)
+		}
 	}
 }
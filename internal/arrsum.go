@@ -1,6 +1,6 @@
 package internal
 
-var program = []uint16{
+var program = []uint64{
 	// load len(arr)
 	/* 00 */ PUSH,
 	/* 01 */ 0,
@@ -62,15 +62,17 @@ var program = []uint16{
 //	      ^
 //	      |
 //	length/result
-func ArraySum(arr []int) int {
-	memory := make([]uint16, 0, len(arr)+1)
-	memory = append(memory, uint16(len(arr)))
+func ArraySum(arr []int) (int, error) {
+	memory := make([]uint64, 0, len(arr)+1)
+	memory = append(memory, uint64(len(arr)))
 	for _, v := range arr {
-		memory = append(memory, uint16(v))
+		memory = append(memory, uint64(v))
 	}
 
-	cpu := WithMemProg(memory, program)
-	cpu.Run()
+	cpu := WithMemProg(program, memory)
+	if err := cpu.Run(); err != nil {
+		return 0, err
+	}
 
-	return int(cpu.MemDump()[0])
+	return int(cpu.DataDump()[0]), nil
 }
@@ -1,6 +1,10 @@
 package internal
 
-import "testing"
+import (
+	"os"
+	"reflect"
+	"testing"
+)
 
 func TestArraySum(t *testing.T) {
 	type args struct {
@@ -28,9 +32,30 @@ func TestArraySum(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := ArraySum(tt.args.arr); got != tt.want {
+			got, err := ArraySum(tt.args.arr)
+			if err != nil {
+				t.Fatalf("ArraySum() error = %v", err)
+			}
+			if got != tt.want {
 				t.Errorf("ArraySum() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+// hand table and arr_sum.raw only differ in the final routine,
+// the table stores the result instead of printing it and skips
+// it for an empty array
+func TestArraySum_matchesSource(t *testing.T) {
+	raw, err := os.ReadFile("../arr_sum.raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const emptyjump, final = 5, 35
+	compiled := compileString(t, string(raw))
+	compiled[emptyjump] = program[emptyjump]
+	if !reflect.DeepEqual(compiled[:final], program[:final]) {
+		t.Errorf("compiled arr_sum.raw = %v, want %v", compiled[:final], program[:final])
+	}
+}
@@ -2,7 +2,10 @@ package internal
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"strconv"
 	"strings"
 )
@@ -10,11 +13,13 @@ import (
 type lexem struct {
 	val string
 	typ int
+	pos Pos
 }
 
 type runeiterator interface {
 	next() rune
 	hasnext() bool
+	pos() Pos
 }
 
 type lexemiterator interface {
@@ -23,58 +28,173 @@ type lexemiterator interface {
 }
 
 type labelref struct {
+	sec  int
 	at   int
 	name string
+	pos  Pos
 }
 
+// exprref is a word to be patched with the value of an
+// expression once all labels are known. bare is set for a
+// single name, which is most likely a misspelled instruction.
+type exprref struct {
+	sec  int
+	at   int
+	node *exprnode
+	pos  Pos
+	bare bool
+}
+
+// compiler emits words into the code or data section,
+// out is indexed by SectionCode and SectionData.
 type compiler struct {
-	lexit  lexemiterator
-	labels map[string]uint16
-	lrefq  []labelref
-	ino    int
+	lexit    lexemiterator
+	labels   map[string]uint64
+	labelpos map[string]Pos
+	labelsec map[string]int
+	lrefq    []labelref
+	exprq    []exprref
+	consts   map[string]*equ
+	constq   []string
+	section  int
+	out      [2][]uint64
+	origins  [2][]Pos
+	cur      Pos
+	pending  *lexem
+	width    int
+	entry    string
+	rep      *reporter
+
+	// object is set when compiling a relocatable object,
+	// label addresses are then offsets in their sections
+	object  bool
+	globals map[string]Pos
+	externs map[string]Pos
+	relocs  []Reloc
+
+	// legacylabels makes every label occupy a NOP
+	// word, as older versions of the assembler did
+	legacylabels bool
+}
+
+// Options control how source is compiled.
+// Entry names the label execution starts at, it may also be
+// set with .entry, program starts at address 0 if neither is.
+// WordWidth may be overridden by .width in the source.
+// Filename is only used in diagnostics. Labels take no space
+// in the program unless LegacyLabels is set.
+// IncludeDirs are searched for .include files not
+// found next to the file that includes them.
+type Options struct {
+	WordWidth    int
+	Entry        string
+	Verbose      bool
+	Filename     string
+	LegacyLabels bool
+	IncludeDirs  []string
 }
 
 func NewCompiler(lexit lexemiterator) *compiler {
 	return &compiler{
-		lexit:  lexit,
-		labels: make(map[string]uint16),
-		lrefq:  make([]labelref, 0),
-		ino:    0,
+		lexit:    lexit,
+		labels:   make(map[string]uint64),
+		labelpos: make(map[string]Pos),
+		labelsec: make(map[string]int),
+		lrefq:    make([]labelref, 0),
+		consts:   make(map[string]*equ),
+		section:  SectionCode,
+		width:    defaultWordWidth,
+		rep:      newreporter(),
 	}
 }
 
-func (c *compiler) compile(verbose bool) ([]uint16, error) {
-	buf := make([]uint16, 0)
+func (c *compiler) compile(verbose bool) ([]uint64, error) {
+	if c.rep == nil {
+		c.rep = newreporter()
+	}
+	if c.labelpos == nil {
+		c.labelpos = make(map[string]Pos)
+	}
+	if c.labelsec == nil {
+		c.labelsec = make(map[string]int)
+	}
+	if c.consts == nil {
+		c.consts = make(map[string]*equ)
+	}
+	if c.globals == nil {
+		c.globals = make(map[string]Pos)
+	}
+	if c.externs == nil {
+		c.externs = make(map[string]Pos)
+	}
+	for i := range c.out {
+		if c.out[i] == nil {
+			c.out[i] = make([]uint64, 0)
+		}
+	}
 
-	for c.lexit.hasnext() {
-		lexem := c.lexit.next()
+	for c.hasnext() {
+		lexem := c.next()
+		c.cur = lexem.pos
 
-		var apnd uint16
 		switch lexem.typ {
 
 		case instruction:
 			if verbose {
 				fmt.Printf("{INSTRUCTION %s}\n", lexem.val)
 			}
-			apnd = c.compileinstr(lexem.val)
+			if !Sinst(strings.ToLower(lexem.val)) {
+				// not a mnemonic, so it should be a constant
+				c.emit(c.compileexpr(lexem))
+				break
+			}
+			if c.section != SectionCode {
+				c.rep.errorf(lexem.pos, "instruction '%s' outside of .text section", lexem.val)
+			}
+			c.emit(c.compileinstr(lexem))
 
 		case integer:
 			if verbose {
 				fmt.Printf("{INTEGER %s}\n", lexem.val)
 			}
-			apnd = c.compileint(lexem.val)
+			val, err := c.compileint(lexem.val)
+			if err != nil {
+				c.rep.errorf(lexem.pos, "%v", err)
+			}
+			c.emit(val)
 
 		case label:
 			if verbose {
 				fmt.Printf("{LABEL %s}\n", lexem.val)
 			}
-			apnd = c.compilelabel(lexem.val)
+			c.compilelabel(lexem)
+			if c.legacylabels && c.section == SectionCode {
+				c.emit(NOP)
+			}
 
 		case labelreference:
 			if verbose {
 				fmt.Printf("{LABELREF %s}\n", lexem.val)
 			}
-			apnd = c.compilelabelref(lexem.val)
+			c.emit(c.compilelabelref(lexem))
+
+		case expression:
+			if verbose {
+				fmt.Printf("{EXPRESSION %s}\n", lexem.val)
+			}
+			c.emit(c.compileexpr(lexem))
+
+		case directive:
+			if verbose {
+				fmt.Printf("{DIRECTIVE %s}\n", lexem.val)
+			}
+			c.compiledirective(lexem)
+
+		case strlit:
+			if verbose {
+				fmt.Printf("{STRING %s}\n", lexem.val)
+			}
+			c.rep.errorf(lexem.pos, "string %s outside of .string directive", lexem.val)
 
 		case comment:
 			if verbose {
@@ -85,73 +205,409 @@ func (c *compiler) compile(verbose bool) ([]uint16, error) {
 		default:
 			return nil, fmt.Errorf("unknown lexem type")
 		}
+	}
 
-		buf = append(buf, apnd)
-		c.ino++
+	c.resolvelabelrefs()
+	return c.out[SectionCode], c.rep.err()
+}
+
+func (c *compiler) hasnext() bool {
+	return c.pending != nil || c.lexit.hasnext()
+}
+
+func (c *compiler) next() lexem {
+	if c.pending != nil {
+		ret := *c.pending
+		c.pending = nil
+		return ret
 	}
+	return c.lexit.next()
+}
 
-	program := c.resolvelabelrefs(buf)
-	return program, nil
+// unread puts a lexem back, so the next call to next() returns it.
+func (c *compiler) unread(lx lexem) {
+	c.pending = &lx
 }
 
-func (c *compiler) compileinstr(value string) uint16 {
-	return uint16(Stoi(strings.ToLower(value)))
+// here is the address of the next word in the current section.
+func (c *compiler) here() uint64 {
+	return uint64(len(c.out[c.section]))
 }
 
-func (c *compiler) compileint(value string) uint16 {
-	asint64, err := strconv.ParseInt(value, 10, 16)
+// emit appends word to the current section and remembers
+// position of the lexem it was compiled from.
+func (c *compiler) emit(word uint64) {
+	c.out[c.section] = append(c.out[c.section], word)
+	c.origins[c.section] = append(c.origins[c.section], c.cur)
+}
+
+// compileinstr reports unknown instructions and
+// compiles them to NOP to keep addresses in place.
+func (c *compiler) compileinstr(lx lexem) uint64 {
+	op, err := StoiSafe(strings.ToLower(lx.val))
 	if err != nil {
-		panic(fmt.Errorf("could not parse int: %w", err))
+		c.rep.errorf(lx.pos, "unknown instruction '%s'", lx.val)
+		return NOP
 	}
-	return uint16(asint64)
+	return uint64(op)
 }
 
-func (c *compiler) compilelabel(value string) uint16 {
-	raw := value[:len(value)-1]
-	if _, ok := c.labels[raw]; ok {
-		panic(fmt.Errorf("attempt to overwrite '%s' label", raw))
+// wordwidth treats zero width as the default one.
+func (c *compiler) wordwidth() int {
+	if c.width == 0 {
+		return defaultWordWidth
 	}
+	return c.width
+}
 
-	c.labels[raw] = uint16(c.ino)
-	return NOP
+// compileint accepts decimal, hex (0x), binary (0b), octal (0o)
+// and character literals. Values are checked against the word
+// width, negative ones are stored in two's complement.
+func (c *compiler) compileint(value string) (uint64, error) {
+	return parseint(value, c.wordwidth())
 }
 
-func (c *compiler) compilelabelref(value string) uint16 {
-	raw := value[1:]
-	labref, ok := c.labels[raw]
-	if !ok {
-		c.lrefq = append(c.lrefq, labelref{
-			at:   c.ino,
-			name: raw,
-		})
+// parseint is shared by plain operands and expressions, so
+// a literal means the same wherever it is written.
+func parseint(value string, width int) (uint64, error) {
+	if strings.HasPrefix(value, "'") {
+		return compilechar(value, width)
+	}
+
+	neg := strings.HasPrefix(value, "-")
+	mag, err := parseuint(strings.TrimPrefix(value, "-"))
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, fmt.Errorf("integer '%s' does not fit into %d bit word", value, width)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("malformed integer '%s'", value)
 	}
-	return labref
+
+	if !neg {
+		if mag > wordmask(width) {
+			return 0, fmt.Errorf("integer '%s' does not fit into %d bit word", value, width)
+		}
+		return mag, nil
+	}
+
+	if mag > 1<<(width-1) {
+		return 0, fmt.Errorf("integer '%s' does not fit into %d bit word", value, width)
+	}
+	return -mag & wordmask(width), nil
 }
 
-func (c *compiler) resolvelabelrefs(prog []uint16) []uint16 {
-	processed := make([]uint16, len(prog))
-	copy(processed, prog)
+// parseuint only takes the formats the lexer does, unlike
+// strconv with base 0 it rejects `010` and `1_000`.
+func parseuint(value string) (uint64, error) {
+	base, digits, isdig := 10, value, digit
+	if len(value) > 1 && value[0] == '0' {
+		switch value[1] {
+		case 'x', 'X':
+			base, isdig = 16, hexdig
+		case 'b', 'B':
+			base, isdig = 2, bindig
+		case 'o', 'O':
+			base, isdig = 8, octdig
+		default:
+			return 0, strconv.ErrSyntax
+		}
+		digits = value[2:]
+	}
+
+	if digits == "" {
+		return 0, strconv.ErrSyntax
+	}
+	for _, r := range digits {
+		if !isdig(r) {
+			return 0, strconv.ErrSyntax
+		}
+	}
+	return strconv.ParseUint(digits, base, 64)
+}
 
+// compilechar decodes a quoted character with go escapes,
+// e.g. 'A', '\n' or '\x7f'.
+func compilechar(value string, width int) (uint64, error) {
+	unq, err := strconv.Unquote(value)
+	if err != nil || len([]rune(unq)) != 1 {
+		return 0, fmt.Errorf("malformed character literal %s", value)
+	}
+
+	r := uint64([]rune(unq)[0])
+	if r > wordmask(width) {
+		return 0, fmt.Errorf("character %s does not fit into %d bit word", value, width)
+	}
+	return r, nil
+}
+
+// compilelabel binds the label to the address
+// of the next emitted word.
+func (c *compiler) compilelabel(lx lexem) {
+	raw := lx.val[:len(lx.val)-1]
+	if _, ok := c.labels[raw]; ok {
+		c.rep.errorf(lx.pos, "label '%s' redefined, previous definition at %s", raw, c.labelpos[raw])
+		return
+	}
+
+	c.labels[raw] = c.here()
+	c.labelpos[raw] = lx.pos
+	c.labelsec[raw] = c.section
+}
+
+// compilelabelref queues every reference, as even resolved
+// ones become relocations when compiling an object.
+func (c *compiler) compilelabelref(lx lexem) uint64 {
+	c.lrefq = append(c.lrefq, labelref{
+		sec:  c.section,
+		at:   len(c.out[c.section]),
+		name: lx.val[1:],
+		pos:  lx.pos,
+	})
+	return 0
+}
+
+func (c *compiler) isextern(name string) bool {
+	_, ok := c.externs[name]
+	return ok
+}
+
+// relocate records that the word holds an address
+// relative to target, which is set when linking.
+func (c *compiler) relocate(sec int, at int, target reloctarget) {
+	if !c.object {
+		return
+	}
+	c.relocs = append(c.relocs, Reloc{
+		Section: sec,
+		Addr:    uint64(at),
+		Target:  target.section,
+		Symbol:  target.symbol,
+	})
+}
+
+// resolvelabelrefs patches label references and then
+// expressions, which may use both labels and constants.
+func (c *compiler) resolvelabelrefs() {
 	for _, labelref := range c.lrefq {
 		ref, ok := c.labels[labelref.name]
+		if !ok && c.object && c.isextern(labelref.name) {
+			c.relocate(labelref.sec, labelref.at, reloctarget{symbol: labelref.name})
+			continue
+		}
 		if !ok {
-			panic(fmt.Errorf("could not backref label '%s'", labelref.name))
+			c.rep.errorf(labelref.pos, "undefined label '%s'", labelref.name)
+			continue
+		}
+
+		c.out[labelref.sec][labelref.at] = ref
+		c.relocate(labelref.sec, labelref.at, reloctarget{section: c.labelsec[labelref.name]})
+	}
+
+	// constants are checked first, so errors in their definitions
+	// are reported once instead of at every use
+	for _, name := range c.constq {
+		e := c.consts[name]
+		if _, err := c.constant(name); err != nil {
+			if err != errConstFailed {
+				c.rep.errorf(e.pos, "%v", err)
+			}
+			e.failed = true
+		}
+	}
+
+	for _, ref := range c.exprq {
+		val, err := c.evalword(ref.node)
+		if err == errConstFailed {
+			continue
+		}
+		if err != nil && ref.bare && c.consts[ref.node.name] == nil {
+			c.rep.errorf(ref.pos, "unknown instruction '%s'", ref.node.name)
+			continue
+		}
+		if err != nil {
+			c.rep.errorf(ref.pos, "%v", err)
+			continue
+		}
+
+		c.out[ref.sec][ref.at] = val
+		if c.object {
+			target, ok, err := c.reloctarget(ref.node)
+			if err != nil {
+				c.rep.errorf(ref.pos, "%v", err)
+				continue
+			}
+			if ok {
+				c.relocate(ref.sec, ref.at, target)
+			}
 		}
+	}
+
+	for name, pos := range c.globals {
+		if _, ok := c.labels[name]; !ok {
+			c.rep.errorf(pos, "global label '%s' is not defined", name)
+		}
+	}
+}
+
+func (c *compiler) evalword(node *exprnode) (uint64, error) {
+	v, err := c.eval(node)
+	if err != nil {
+		return 0, err
+	}
+	return toword(v, c.wordwidth())
+}
+
+// parseoperand parses an integer, label reference,
+// constant name or expression lexem.
+func (c *compiler) parseoperand(lx lexem) (*exprnode, bool) {
+	node, err := parseexpr(lx.val)
+	if err != nil {
+		pos := lx.pos
+		var perr *exprerror
+		if errors.As(err, &perr) {
+			pos.Col += perr.at
+		}
+		c.rep.errorf(pos, "%v", err)
+		return nil, false
+	}
+	return node, true
+}
+
+// compileexpr queues operand to be evaluated after
+// label resolution and emits a placeholder for it.
+func (c *compiler) compileexpr(lx lexem) uint64 {
+	node, ok := c.parseoperand(lx)
+	if !ok {
+		return 0
+	}
+
+	c.exprq = append(c.exprq, exprref{
+		sec:  c.section,
+		at:   len(c.out[c.section]),
+		node: node,
+		pos:  lx.pos,
+		bare: lx.typ == instruction,
+	})
+	return 0
+}
+
+func Compile(in bufio.Reader, verbose bool) ([]uint64, error) {
+	return CompileWith(in, Options{Verbose: verbose})
+}
 
-		processed[labelref.at] = ref
+func CompileWith(in bufio.Reader, opts Options) ([]uint64, error) {
+	img, err := Assemble(in, opts)
+	if err != nil {
+		return nil, err
 	}
+	return img.Code, nil
+}
 
-	return processed
+// Assemble compiles source into an executable image.
+// Errors in source are returned as Diagnostics.
+func Assemble(in bufio.Reader, opts Options) (*Image, error) {
+	src, err := io.ReadAll(&in)
+	if err != nil {
+		return nil, fmt.Errorf("could not read source: %w", err)
+	}
+	return assemble([]source{{name: opts.Filename, text: string(src)}}, opts)
 }
 
-func Compile(in bufio.Reader, verbose bool) ([]uint16, error) {
-	rit := newruneiter(in)
-	lexit := newfsmlex(&rit)
-	comp := NewCompiler(lexit)
+// AssembleFiles compiles several files into one image,
+// as if they were included one after another.
+func AssembleFiles(paths []string, opts Options) (*Image, error) {
+	srcs, err := readsources(paths)
+	if err != nil {
+		return nil, err
+	}
+	return assemble(srcs, opts)
+}
 
-	prog, err := comp.compile(verbose)
+func readsources(paths []string) ([]source, error) {
+	srcs := make([]source, 0, len(paths))
+	for _, path := range paths {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		srcs = append(srcs, source{name: path, text: string(text)})
+	}
+	return srcs, nil
+}
+
+func assemble(srcs []source, opts Options) (*Image, error) {
+	comp, inc, err := compilesources(srcs, opts, false)
 	if err != nil {
-		return nil, fmt.Errorf("compiler.compile(): %w", err)
+		return nil, err
+	}
+
+	img := &Image{
+		ISAVersion: ISAVersion,
+		WordWidth:  comp.width,
+		Code:       comp.out[SectionCode],
+		Data:       comp.out[SectionData],
+		Symbols:    comp.symbols(),
+		Debug:      comp.debuginfo(inc.files),
+	}
+
+	name := opts.Entry
+	if name == "" {
+		name = comp.entry
+	} else if comp.entry != "" && comp.entry != name {
+		return nil, fmt.Errorf("entry label '%s' differs from '%s' set by .entry", name, comp.entry)
+	}
+	if name != "" {
+		entry, ok := comp.labels[name]
+		if !ok {
+			return nil, fmt.Errorf("entry label '%s' is not defined", name)
+		}
+		if comp.labelsec[name] != SectionCode {
+			return nil, fmt.Errorf("entry label '%s' is not in code section", name)
+		}
+		img.Entry = entry
+	}
+
+	return img, nil
+}
+
+// compilesources runs sources through includer, macro expander
+// and compiler, object tells to compile a relocatable object.
+func compilesources(srcs []source, opts Options, object bool) (*compiler, *includer, error) {
+	if opts.WordWidth == 0 {
+		opts.WordWidth = defaultWordWidth
+	}
+	if !validwidth(opts.WordWidth) {
+		return nil, nil, fmt.Errorf("unsupported word width: %d", opts.WordWidth)
+	}
+
+	rep := newreporter()
+	inc := newincluder(srcs, opts.IncludeDirs, rep)
+
+	comp := NewCompiler(newmacroexpander(inc, rep))
+	comp.width = opts.WordWidth
+	comp.rep = rep
+	comp.legacylabels = opts.LegacyLabels
+	comp.object = object
+
+	if _, err := comp.compile(opts.Verbose); err != nil {
+		return nil, nil, err
+	}
+	return comp, inc, nil
+}
+
+func (c *compiler) symbols() []Symbol {
+	syms := make([]Symbol, 0, len(c.labels))
+	for name, addr := range c.labels {
+		// macro local labels are not exported
+		if strings.Contains(name, "@") {
+			continue
+		}
+		syms = append(syms, Symbol{
+			Name:    name,
+			Section: c.labelsec[name],
+			Addr:    addr,
+		})
 	}
-	return prog, nil
+	sortsymbols(syms)
+	return syms
 }
@@ -135,33 +135,34 @@ func Test_compiler_compile(t *testing.T) {
 	tests := []struct {
 		name    string
 		c       *compiler
-		want    []uint16
-		wlabels map[string]uint16
+		want    []uint64
+		wlabels map[string]uint64
 	}{
 		{
 			name: "should compile stream of instructions",
 			c: &compiler{
 				lexit:  fsmlexFromString("add In jMp NOP"),
-				labels: make(map[string]uint16),
+				labels: make(map[string]uint64),
 			},
-			want: []uint16{ADD, IN, JMP, NOP},
+			want: []uint64{ADD, IN, JMP, NOP},
 		},
 		{
 			name: "should compile stream of numbers",
 			c: &compiler{
 				lexit:  fsmlexFromString("1 2 3 123 456"),
-				labels: make(map[string]uint16),
+				labels: make(map[string]uint64),
 			},
-			want: []uint16{1, 2, 3, 123, 456},
+			want: []uint64{1, 2, 3, 123, 456},
 		},
 		{
-			name: "should compile stream of labels terminated by instruction",
+			name: "should compile stream of labels terminated by instruction in legacy mode",
 			c: &compiler{
-				lexit:  fsmlexFromString("a: b: c: d: add"),
-				labels: make(map[string]uint16),
+				legacylabels: true,
+				lexit:        fsmlexFromString("a: b: c: d: add"),
+				labels:       make(map[string]uint64),
 			},
-			want: []uint16{NOP, NOP, NOP, NOP, ADD},
-			wlabels: map[string]uint16{
+			want: []uint64{NOP, NOP, NOP, NOP, ADD},
+			wlabels: map[string]uint64{
 				"a": 0,
 				"b": 1,
 				"c": 2,
@@ -169,13 +170,14 @@ func Test_compiler_compile(t *testing.T) {
 			},
 		},
 		{
-			name: "should compile stream of labels not terminated by instruction",
+			name: "should compile stream of labels not terminated by instruction in legacy mode",
 			c: &compiler{
-				lexit:  fsmlexFromString("a: b: c: d:"),
-				labels: make(map[string]uint16),
+				legacylabels: true,
+				lexit:        fsmlexFromString("a: b: c: d:"),
+				labels:       make(map[string]uint64),
 			},
-			want: []uint16{NOP, NOP, NOP, NOP},
-			wlabels: map[string]uint16{
+			want: []uint64{NOP, NOP, NOP, NOP},
+			wlabels: map[string]uint64{
 				"a": 0,
 				"b": 1,
 				"c": 2,
@@ -186,38 +188,74 @@ func Test_compiler_compile(t *testing.T) {
 			name: "should compile stream of label refs",
 			c: &compiler{
 				lexit: fsmlexFromString("&a &b &c &d"),
-				labels: map[string]uint16{
+				labels: map[string]uint64{
 					"a": 123,
 					"b": 456,
 					"c": 789,
 					"d": 1011,
 				},
 			},
-			want: []uint16{123, 456, 789, 1011},
+			want: []uint64{123, 456, 789, 1011},
+		},
+		{
+			name: "should reference label crated before in legacy mode",
+			c: &compiler{
+				legacylabels: true,
+				lexit:        fsmlexFromString("add nop a: load &a jmp"),
+				labels:       make(map[string]uint64),
+			},
+			want: []uint64{ADD, NOP, NOP, LOAD, 2, JMP},
+		},
+		{
+			name: "should be able to reference stacked lablels in legacy mode",
+			c: &compiler{
+				legacylabels: true,
+				lexit:        fsmlexFromString("add nop a: b: load &a &b jmp"),
+				labels:       make(map[string]uint64),
+			},
+			want: []uint64{ADD, NOP, NOP, NOP, LOAD, 2, 3, JMP},
 		},
 		{
-			name: "should reference label crated before",
+			name: "should be able to resolve references before labels in legacy mode",
 			c: &compiler{
-				lexit:  fsmlexFromString("add nop a: load &a jmp"),
-				labels: make(map[string]uint16),
+				legacylabels: true,
+				lexit:        fsmlexFromString("add nop &a &b load a: b: jmp"),
+				labels:       make(map[string]uint64),
 			},
-			want: []uint16{ADD, NOP, NOP, LOAD, 2, JMP},
+			want: []uint64{ADD, NOP, 5, 6, LOAD, NOP, NOP, JMP},
 		},
 		{
-			name: "should be able to reference stacked lablels",
+			name: "labels should take no space",
 			c: &compiler{
-				lexit:  fsmlexFromString("add nop a: b: load &a &b jmp"),
-				labels: make(map[string]uint16),
+				lexit:  fsmlexFromString("a: b: c: d: add"),
+				labels: make(map[string]uint64),
+			},
+			want: []uint64{ADD},
+			wlabels: map[string]uint64{
+				"a": 0,
+				"b": 0,
+				"c": 0,
+				"d": 0,
+			},
+		},
+		{
+			name: "labels at the end should point past the program",
+			c: &compiler{
+				lexit:  fsmlexFromString("add a:"),
+				labels: make(map[string]uint64),
+			},
+			want: []uint64{ADD},
+			wlabels: map[string]uint64{
+				"a": 1,
 			},
-			want: []uint16{ADD, NOP, NOP, NOP, LOAD, 2, 3, JMP},
 		},
 		{
-			name: "should be able to resolve references before labels",
+			name: "should resolve zero width labels before and after references",
 			c: &compiler{
-				lexit:  fsmlexFromString("add nop &a &b load a: b: jmp"),
-				labels: make(map[string]uint16),
+				lexit:  fsmlexFromString("a: add nop &a &b load b: jmp"),
+				labels: make(map[string]uint64),
 			},
-			want: []uint16{ADD, NOP, 5, 6, LOAD, NOP, NOP, JMP},
+			want: []uint64{ADD, NOP, 0, 5, LOAD, JMP},
 		},
 	}
 	for _, tt := range tests {
@@ -234,3 +272,63 @@ func Test_compiler_compile(t *testing.T) {
 		})
 	}
 }
+
+func Test_compiler_compileint(t *testing.T) {
+	tests := []struct {
+		name    string
+		width   int
+		value   string
+		want    uint64
+		wantErr bool
+	}{
+		{name: "unsigned 16 bit", width: 16, value: "65535", want: 0xFFFF},
+		{name: "negative 16 bit", width: 16, value: "-1", want: 0xFFFF},
+		{name: "too large for 16 bit", width: 16, value: "65536", wantErr: true},
+		{name: "fits into 32 bit", width: 32, value: "65536", want: 0x10000},
+		{name: "negative 32 bit", width: 32, value: "-2", want: 0xFFFFFFFE},
+		{name: "unsigned 64 bit", width: 64, value: "18446744073709551615", want: 0xFFFFFFFFFFFFFFFF},
+		{name: "hex", width: 16, value: "0xFFFF", want: 0xFFFF},
+		{name: "upper case hex prefix", width: 16, value: "0X1f", want: 0x1F},
+		{name: "hex too large for 16 bit", width: 16, value: "0x10000", wantErr: true},
+		{name: "binary", width: 16, value: "0b101", want: 5},
+		{name: "octal", width: 16, value: "0o17", want: 0o17},
+		{name: "negative hex", width: 16, value: "-0x8000", want: 0x8000},
+		{name: "most negative 16 bit", width: 16, value: "-32768", want: 0x8000},
+		{name: "too negative for 16 bit", width: 16, value: "-32769", wantErr: true},
+		{name: "most negative 64 bit", width: 64, value: "-9223372036854775808", want: 0x8000000000000000},
+		{name: "too large for 64 bit", width: 64, value: "18446744073709551616", wantErr: true},
+		{name: "malformed hex", width: 16, value: "0x", wantErr: true},
+		{name: "leading zero", width: 16, value: "010", wantErr: true},
+		{name: "underscore", width: 16, value: "1_000", wantErr: true},
+		{name: "char", width: 16, value: "'A'", want: 'A'},
+		{name: "escaped char", width: 16, value: "'\\n'", want: '\n'},
+		{name: "escaped quote", width: 16, value: "'\\''", want: '\''},
+		{name: "hex escape", width: 16, value: "'\\x7f'", want: 0x7F},
+		{name: "unicode char", width: 16, value: "'ж'", want: 'ж'},
+		{name: "char too large for 16 bit", width: 16, value: "'😀'", wantErr: true},
+		{name: "char fits into 32 bit", width: 32, value: "'😀'", want: '😀'},
+		{name: "several chars", width: 16, value: "'ab'", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &compiler{width: tt.width}
+
+			got, err := c.compileint(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("compiler.compileint() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("compiler.compileint() = %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompile_literals(t *testing.T) {
+	got := compileString(t, "push 'A' out push 0x1F push -1 push 0b11 push 0o10 push '\\n' out")
+	want := []uint64{PUSH, 'A', OUT, PUSH, 0x1F, PUSH, 0xFFFF, PUSH, 3, PUSH, 8, PUSH, '\n', OUT}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Compile() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,45 @@
+package internal
+
+import "fmt"
+
+// Config describes the machine geometry. WordWidth is one of
+// 16, 32 or 64 bits, zero means 16. Signed turns on signed
+// arithmetic mode, where DIV, MOD and OUTNUM treat words as
+// two's complement numbers.
+type Config struct {
+	ProgramWords int  `json:"program_words"`
+	DataWords    int  `json:"data_words"`
+	StackDepth   int  `json:"stack_depth"`
+	ReturnDepth  int  `json:"return_depth"`
+	WordWidth    int  `json:"word_width"`
+	Signed       bool `json:"signed,omitempty"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ProgramWords: MemSize,
+		DataWords:    MemSize,
+		StackDepth:   StackLimit,
+		ReturnDepth:  ReturnLimit,
+		WordWidth:    defaultWordWidth,
+	}
+}
+
+func (cfg Config) validate() error {
+	if cfg.ProgramWords <= 0 {
+		return fmt.Errorf("program memory must hold at least one word, got %d", cfg.ProgramWords)
+	}
+	if cfg.DataWords < 0 {
+		return fmt.Errorf("data memory size must not be negative, got %d", cfg.DataWords)
+	}
+	if cfg.StackDepth <= 0 {
+		return fmt.Errorf("stack must hold at least one word, got %d", cfg.StackDepth)
+	}
+	if cfg.ReturnDepth < 0 {
+		return fmt.Errorf("return stack depth must not be negative, got %d", cfg.ReturnDepth)
+	}
+	if !validwidth(cfg.width()) {
+		return fmt.Errorf("unsupported word width: %d", cfg.WordWidth)
+	}
+	return nil
+}
@@ -2,62 +2,92 @@ package internal
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
+	"math"
+	"math/bits"
 	"os"
+	"strings"
 )
 
 const (
-	MemSize    int = 80
-	StackLimit int = 16
-	dumpWidth      = 8
+	MemSize     int = 80
+	StackLimit  int = 16
+	ReturnLimit int = 16
+	dumpWidth       = 8
 )
 
-type handler func()
+type handler func() error
 
 type cpu struct {
-	stack   []uint16
-	program []uint16
-	data    []uint16
-	cnt     uint16
+	cfg     Config
+	stack   []uint64
+	rstack  []uint64
+	program []uint64
+	data    []uint64
+	cnt     uint64
+	flags   uint64
 	sp      int
+	rsp     int
 	ip      int
 	hmap    map[int]handler
 	running bool
+	in      io.ByteReader
+	out     io.Writer
+
+	// at is the address of the instruction being executed,
+	// entrysp and undo let rebuild the stack it started with
+	at      int
+	entrysp int
+	undo    []memchange
+	steps   int
+	hooks   hooks
+	tracer  Tracer
+	trace   *TraceRecord
+	history *history
 }
 
 func NewCpu() *cpu {
-	return newcpu()
+	return newcpu(DefaultConfig())
 }
 
-func newcpu() *cpu {
-	ret := &cpu{}
+func newcpu(cfg Config) *cpu {
+	ret := &cpu{cfg: cfg}
 	ret.init()
 	return ret
 }
 
 func (c *cpu) init() {
 	c.initstack()
+	c.initrstack()
 	c.initmem()
 	c.initdata()
 	c.initsp()
 	c.inithmap()
 	c.initrunning()
+	c.initio()
 }
 
 func (c *cpu) initstack() {
-	c.stack = make([]uint16, StackLimit)
+	c.stack = make([]uint64, c.cfg.StackDepth)
+}
+
+func (c *cpu) initrstack() {
+	c.rstack = make([]uint64, c.cfg.ReturnDepth)
 }
 
 func (c *cpu) initdata() {
-	c.data = make([]uint16, MemSize)
+	c.data = make([]uint64, c.cfg.DataWords)
 }
 
 func (c *cpu) initmem() {
-	c.program = make([]uint16, MemSize)
+	c.program = make([]uint64, c.cfg.ProgramWords)
 }
 
 func (c *cpu) initsp() {
 	c.sp = -1
+	c.rsp = -1
 }
 
 func (c *cpu) inithmap() {
@@ -89,6 +119,22 @@ func (c *cpu) inithmap() {
 		TERM:   c.iterm,
 		OUTNUM: c.ioutnum,
 		MUL:    c.imul,
+		CALL:   c.icall,
+		RET:    c.iret,
+		DIV:    c.idiv,
+		MOD:    c.imod,
+		SHL:    c.ishl,
+		SHR:    c.ishr,
+		SAR:    c.isar,
+		EQ:     c.ieq,
+		LT:     c.ilt,
+		GT:     c.igt,
+		ULT:    c.iult,
+		UGT:    c.iugt,
+		PUSHF:  c.ipushf,
+		JC:     c.ijc,
+		JO:     c.ijo,
+		JN:     c.ijn,
 	}
 }
 
@@ -96,31 +142,107 @@ func (c *cpu) initrunning() {
 	c.running = true
 }
 
-func WithMemProg(program []uint16, data []uint16) *cpu {
-	ret := newcpu()
+func (c *cpu) initio() {
+	c.SetIO(os.Stdin, os.Stdout)
+}
+
+// SetIO attaches devices used by IN, OUT and OUTNUM.
+// Readers that are not io.ByteReader get buffered once,
+// so bytes read ahead are kept between IN instructions.
+func (c *cpu) SetIO(in io.Reader, out io.Writer) {
+	if br, ok := in.(io.ByteReader); ok {
+		c.in = br
+	} else {
+		c.in = bufio.NewReader(in)
+	}
+	c.out = out
+}
+
+// WithMemProg creates a cpu with the default geometry,
+// enlarged if program or data do not fit into it.
+func WithMemProg(program []uint64, data []uint64) *cpu {
+	cfg := DefaultConfig()
+	if len(program) > cfg.ProgramWords {
+		cfg.ProgramWords = len(program)
+	}
+	if len(data) > cfg.DataWords {
+		cfg.DataWords = len(data)
+	}
+
+	ret := newcpu(cfg)
 	copy(ret.program, program)
 	copy(ret.data, data)
 	return ret
 }
 
-func (c *cpu) MemDump() []uint16 {
-	dump := make([]uint16, MemSize)
+func WithConfig(cfg Config, program []uint64, data []uint64) (*cpu, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if len(program) > cfg.ProgramWords {
+		return nil, fmt.Errorf(
+			"program of %d words does not fit into %d words of program memory",
+			len(program), cfg.ProgramWords,
+		)
+	}
+	if len(data) > cfg.DataWords {
+		return nil, fmt.Errorf(
+			"data of %d words does not fit into %d words of data memory",
+			len(data), cfg.DataWords,
+		)
+	}
+
+	ret := newcpu(cfg)
+	copy(ret.program, program)
+	copy(ret.data, data)
+	return ret, nil
+}
+
+// FromImage creates a cpu loaded with code and initial data of
+// the image, starting at its entry point. Word width of the
+// image overrides the one in cfg.
+func FromImage(cfg Config, img *Image) (*cpu, error) {
+	cfg.WordWidth = img.WordWidth
+
+	ret, err := WithConfig(cfg, img.Code, img.Data)
+	if err != nil {
+		return nil, err
+	}
+	if img.Entry >= uint64(len(ret.program)) {
+		return nil, fmt.Errorf("entry point %#04x is outside of program memory", img.Entry)
+	}
+	ret.ip = int(img.Entry)
+	return ret, nil
+}
+
+func (c *cpu) Config() Config {
+	return c.cfg
+}
+
+func (c *cpu) MemDump() []uint64 {
+	dump := make([]uint64, len(c.program))
 	copy(dump, c.program)
 	return dump
 }
 
-func (c *cpu) DataDump() []uint16 {
-	dump := make([]uint16, MemSize)
+func (c *cpu) DataDump() []uint64 {
+	dump := make([]uint64, len(c.data))
 	copy(dump, c.data)
 	return dump
 }
 
-func (c *cpu) StackDump() []uint16 {
-	dump := make([]uint16, StackLimit)
+func (c *cpu) StackDump() []uint64 {
+	dump := make([]uint64, len(c.stack))
 	copy(dump, c.stack)
 	return dump
 }
 
+func (c *cpu) ReturnStackDump() []uint64 {
+	dump := make([]uint64, len(c.rstack))
+	copy(dump, c.rstack)
+	return dump
+}
+
 func (c *cpu) GetSp() int {
 	return c.sp
 }
@@ -129,77 +251,99 @@ func (c *cpu) GetIp() int {
 	return c.ip
 }
 
-func (c *cpu) Run() {
+// Run executes instructions until TERM or the first fault.
+func (c *cpu) Run() error {
+	_, err := c.RunContext(context.Background(), 0)
+	return err
+}
+
+// RunContext executes at most maxSteps instructions (no limit
+// if maxSteps <= 0) and returns how many of them were executed.
+// A nil error means the program terminated, otherwise the error
+// is a *Fault, a *Break, ErrStepLimit or the context error.
+func (c *cpu) RunContext(ctx context.Context, maxSteps int) (int, error) {
+	steps := 0
 	for c.running {
-		c.tick()
+		if maxSteps > 0 && steps >= maxSteps {
+			return steps, ErrStepLimit
+		}
+
+		select {
+		case <-ctx.Done():
+			return steps, ctx.Err()
+		default:
+		}
+
+		err := c.tick()
+		if _, ok := err.(*Break); ok {
+			return steps + 1, err
+		}
+		if err != nil {
+			return steps, err
+		}
+		steps++
 	}
+	return steps, nil
 }
 
-func border() string {
-	line := "-"
+func border(digits int) string {
+	line := "--------"
 	for i := 0; i < dumpWidth; i++ {
-		line += "---------"
-	}
-	for i := 0; i < dumpWidth-1; i++ {
-		line += "-"
+		line += strings.Repeat("-", digits+5)
 	}
 	return fmt.Sprintf("+%s+\n", line)
 }
 
-func dataHeader() string {
-	res := "|  data  |"
+func header(title string, digits int) string {
+	res := fmt.Sprintf("| %-6s |", title)
 	for i := 0; i < dumpWidth; i++ {
-		res += fmt.Sprintf("     +%d |", i)
+		res += fmt.Sprintf(" %*s |", digits+2, fmt.Sprintf("+%d", i))
 	}
 	res += "\r\n"
 	return res
 }
 
-func memHeader() string {
-	res := "|  instr |"
-	for i := 0; i < dumpWidth; i++ {
-		res += fmt.Sprintf("     +%d |", i)
-	}
-	res += "\r\n"
-	return res
+func dataHeader(digits int) string {
+	return header(" data", digits)
 }
 
-func stackHeader() string {
-	res := "| stack  |"
-	for i := 0; i < dumpWidth; i++ {
-		res += fmt.Sprintf("     +%d |", i)
-	}
-	res += "\r\n"
-	return res
+func memHeader(digits int) string {
+	return header(" instr", digits)
 }
 
-func formatNumber(num uint16) string {
-	prefix := "0x"
-	snum := fmt.Sprintf("%x", num)
-	for len(prefix)+len(snum) < len("0x0000") {
-		prefix += "0"
-	}
+func stackHeader(digits int) string {
+	return header("stack", digits)
+}
 
-	if num == 0 {
-		return prefix + snum
-	}
+func rstackHeader(digits int) string {
+	return header("rstack", digits)
+}
 
-	return prefix + snum
+func formatNumber(num uint64, digits int) string {
+	return fmt.Sprintf("0x%0*x", digits, num)
 }
 
-func dtable(dump []uint16) string {
+func dtable(dump []uint64, digits int) string {
 	res := ""
 	for i := 0; i < len(dump); i++ {
 		if i%dumpWidth == 0 {
-			res += fmt.Sprintf("| %#04x |", i/dumpWidth*dumpWidth)
+			res += fmt.Sprintf("| 0x%04x |", i/dumpWidth*dumpWidth)
 		}
 
-		res += fmt.Sprintf(" %s |", formatNumber(dump[i]))
+		res += fmt.Sprintf(" %s |", formatNumber(dump[i], digits))
 
 		if i%dumpWidth == dumpWidth-1 {
 			res += "\r\n"
 		}
 	}
+
+	// pad the last row if memory size is not a multiple of dumpWidth
+	if rem := len(dump) % dumpWidth; rem != 0 {
+		for i := rem; i < dumpWidth; i++ {
+			res += strings.Repeat(" ", digits+4) + "|"
+		}
+		res += "\r\n"
+	}
 	return res
 }
 
@@ -207,83 +351,190 @@ func (c *cpu) Dump() string {
 	md := c.MemDump()
 	dd := c.DataDump()
 	sd := c.StackDump()
+	rd := c.ReturnStackDump()
+	digits := c.cfg.width() / 4
 
 	res := ""
-	res += border()
-	res += memHeader()
-	res += dtable(md)
-	res += border()
+	res += border(digits)
+	res += memHeader(digits)
+	res += dtable(md, digits)
+	res += border(digits)
 
 	res += "\n"
 
-	res += border()
-	res += dataHeader()
-	res += dtable(dd)
-	res += border()
+	res += border(digits)
+	res += dataHeader(digits)
+	res += dtable(dd, digits)
+	res += border(digits)
 
 	res += "\n"
 
-	res += border()
-	res += stackHeader()
-	res += dtable(sd)
-	res += border()
+	res += border(digits)
+	res += stackHeader(digits)
+	res += dtable(sd, digits)
+	res += border(digits)
 
 	res += "\n"
 
+	if len(rd) != 0 {
+		res += border(digits)
+		res += rstackHeader(digits)
+		res += dtable(rd, digits)
+		res += border(digits)
+
+		res += "\n"
+	}
+
 	res += fmt.Sprintf("counter register: %d\n", c.cnt)
+	res += fmt.Sprintf("flags: %s\n", formatFlags(c.flags))
 	res += fmt.Sprintf("stack pointer: %d\n", c.sp)
+	res += fmt.Sprintf("return stack pointer: %d\n", c.rsp)
 	res += fmt.Sprintf("instruction pointer: %d\n", c.ip)
 
 	return res
 }
 
-func (c *cpu) tick() {
+func (c *cpu) tick() error {
 	if !c.running {
-		panic("attempt to tick when not running")
+		return ErrHalted
+	}
+
+	c.at = c.ip
+	c.entrysp = c.sp
+	c.undo = c.undo[:0]
+	c.record()
+	c.tracestart()
+	fetched, err := c.fetch()
+	if err != nil {
+		f := c.fault(err, c.at, 0)
+		c.traceend(f)
+		return f
 	}
-	fetched := c.fetch()
+
 	decoded := c.decode(fetched)
-	c.execute(decoded)
+	if err := c.execute(decoded); err != nil {
+		c.hooks.events = nil
+		f := c.fault(err, c.at, decoded)
+		c.traceend(f)
+		return f
+	}
+	c.steps++
+	c.traceend(nil)
+
+	if c.running {
+		c.checkbreaks()
+	}
+	return c.flushevents()
 }
 
-func (c *cpu) Tick() {
-	c.tick()
+func (c *cpu) Tick() error {
+	return c.tick()
 }
 
-func (c *cpu) fetch() uint16 {
+// fault stops the cpu and rewinds the instruction pointer
+// to the instruction that caused the fault, the stack is
+// reported as it was before that instruction.
+func (c *cpu) fault(err error, at int, opcode uint64) *Fault {
+	kind, ok := err.(FaultKind)
+	if ok {
+		err = nil
+	} else {
+		kind = FaultIO
+	}
+
+	c.ip = at
+	c.terminate()
+
+	return &Fault{
+		Kind:   kind,
+		IP:     at,
+		Opcode: opcode,
+		Stack:  c.entrystack(),
+		Err:    err,
+	}
+}
+
+func (c *cpu) fetch() (uint64, error) {
+	if c.ip < 0 || c.ip >= len(c.program) {
+		return 0, FaultFetch
+	}
 	cmd := c.program[c.ip]
 	c.ip++
-	return cmd
+	return cmd, nil
 }
 
-func (c *cpu) decode(opcode uint16) uint16 {
+func (c *cpu) decode(opcode uint64) uint64 {
 	return opcode
 }
 
-func (c *cpu) execute(cmd uint16) {
+func (c *cpu) execute(cmd uint64) error {
 	h, ok := c.hmap[int(cmd)]
 	if !ok {
-		panic("unknown command")
+		return FaultBadOpcode
 	}
-	h()
+	return h()
 }
 
-func (c *cpu) push(x uint16) {
-	if c.sp == StackLimit-1 {
-		panic("stack overflow")
+func (c *cpu) push(x uint64) error {
+	if c.sp == len(c.stack)-1 {
+		return FaultStackOverflow
 	}
 	c.sp++
-	c.stack[c.sp] = x
+	c.remember(memStack, c.sp, c.stack[c.sp])
+	c.stack[c.sp] = x & c.mask()
+	return nil
 }
 
-func (c *cpu) pop() uint16 {
+func (c *cpu) pop() (uint64, error) {
 	if c.sp == -1 {
-		panic("stack underflow")
+		return 0, FaultStackUnderflow
 	}
 	ret := c.stack[c.sp]
+	c.remember(memStack, c.sp, ret)
 	c.stack[c.sp] = 0
 	c.sp--
-	return ret
+	return ret, nil
+}
+
+func (c *cpu) rpush(x uint64) error {
+	if c.rsp == len(c.rstack)-1 {
+		return FaultReturnOverflow
+	}
+	c.rsp++
+	c.remember(memRstack, c.rsp, c.rstack[c.rsp])
+	c.rstack[c.rsp] = x
+	return nil
+}
+
+func (c *cpu) rpop() (uint64, error) {
+	if c.rsp == -1 {
+		return 0, FaultReturnUnderflow
+	}
+	ret := c.rstack[c.rsp]
+	c.remember(memRstack, c.rsp, ret)
+	c.rstack[c.rsp] = 0
+	c.rsp--
+	return ret, nil
+}
+
+// pop a, pop b
+func (c *cpu) pop2() (uint64, uint64, error) {
+	a, err := c.pop()
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := c.pop()
+	if err != nil {
+		return 0, 0, err
+	}
+	return a, b, nil
+}
+
+func (c *cpu) daddr(a uint64) (int, error) {
+	if a >= uint64(len(c.data)) {
+		return 0, FaultDataAddress
+	}
+	return int(a), nil
 }
 
 func (c *cpu) terminate() {
@@ -291,173 +542,436 @@ func (c *cpu) terminate() {
 }
 
 // do nothing
-func (c *cpu) inop() {}
+func (c *cpu) inop() error {
+	return nil
+}
 
 // pop a, pop b, push a + b
-func (c *cpu) iadd() {
-	c.push(c.pop() + c.pop())
+func (c *cpu) iadd() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	sum, carry := bits.Add64(a, b, 0)
+	res := sum & c.mask()
+	wrapped := carry != 0 || sum != res
+	overflow := (a^res)&(b^res)&c.signbit() != 0
+	return c.pushres(res, wrapped, overflow)
 }
 
 // pop a, pop b, push b - a
 // !!! may not be desired behaviour !!!
-func (c *cpu) isub() {
-	t := c.pop()
-	nt := c.pop()
-	c.push(nt - t)
+func (c *cpu) isub() error {
+	t, nt, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	res := (nt - t) & c.mask()
+	borrow := nt < t
+	overflow := (nt^t)&(nt^res)&c.signbit() != 0
+	return c.pushres(res, borrow, overflow)
 }
 
 // pop a, pop b, push a & b
-func (c *cpu) iand() {
-	a := c.pop()
-	b := c.pop()
-	c.push(a & b)
+func (c *cpu) iand() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	return c.pushres(a&b, false, false)
 }
 
 // pop a, pop b, push a | b
-func (c *cpu) ior() {
-	a := c.pop()
-	b := c.pop()
-	c.push(a | b)
+func (c *cpu) ior() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	return c.pushres(a|b, false, false)
 }
 
 // pop a, pop b, push a ^ b
-func (c *cpu) ixor() {
-	a := c.pop()
-	b := c.pop()
-	c.push(a ^ b)
+func (c *cpu) ixor() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	return c.pushres(a^b, false, false)
 }
 
 // pop a, push !a
-func (c *cpu) inot() {
-	c.push(^c.pop())
+func (c *cpu) inot() error {
+	a, err := c.pop()
+	if err != nil {
+		return err
+	}
+	return c.pushres(^a&c.mask(), false, false)
 }
 
-// read one byte from stdin and push to the stack
-func (c *cpu) iin() {
-	in := bufio.NewReader(os.Stdin)
-	b, err := in.ReadByte()
+// read one byte from input device and push to the stack
+func (c *cpu) iin() error {
+	b, err := c.in.ReadByte()
 	if err != nil {
-		panic(err)
+		return err
 	}
-	c.push(uint16(b))
+	return c.push(uint64(b))
 }
 
-// write top of the stack into stdout
-func (c *cpu) iout() {
-	out := bufio.NewWriter(os.Stdout)
-	if err := out.WriteByte(byte(c.pop())); err != nil {
-		panic(err)
+// write top of the stack into output device
+func (c *cpu) iout() error {
+	a, err := c.pop()
+	if err != nil {
+		return err
 	}
+	_, err = c.out.Write([]byte{byte(a)})
+	return err
 }
 
 // pop a, push word read from memory[a]
-func (c *cpu) iload() {
-	c.push(c.data[c.pop()])
+func (c *cpu) iload() error {
+	a, err := c.pop()
+	if err != nil {
+		return err
+	}
+	addr, err := c.daddr(a)
+	if err != nil {
+		return err
+	}
+	return c.push(c.readdata(addr))
 }
 
 // pop a, pop b, write b to memory[a]
-func (c *cpu) istor() {
-	a := c.pop()
-	c.data[a] = c.pop()
+func (c *cpu) istor() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	addr, err := c.daddr(a)
+	if err != nil {
+		return err
+	}
+	c.writedata(addr, b)
+	return nil
 }
 
 // pop a, goto a
-func (c *cpu) ijmp() {
-	c.ip = int(c.pop())
+func (c *cpu) ijmp() error {
+	a, err := c.pop()
+	if err != nil {
+		return err
+	}
+	c.ip = int(a)
+	return nil
 }
 
 // pop a, pop b, if a == 0 goto b
-func (c *cpu) ijz() {
-	a := c.pop()
-	b := c.pop()
+func (c *cpu) ijz() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
 	if a == 0 {
 		c.ip = int(b)
 	}
+	return nil
 }
 
 // push next word
-func (c *cpu) ipush() {
-	c.push(c.program[c.ip])
-	c.ip++
+func (c *cpu) ipush() error {
+	val, err := c.fetch()
+	if err != nil {
+		return err
+	}
+	return c.push(val)
 }
 
 // duplicate stack top
-func (c *cpu) idup() {
-	val := c.pop()
-	c.push(val)
-	c.push(val)
+func (c *cpu) idup() error {
+	val, err := c.pop()
+	if err != nil {
+		return err
+	}
+	if err := c.push(val); err != nil {
+		return err
+	}
+	return c.push(val)
 }
 
 // swap two top values
-func (c *cpu) iswap() {
-	a := c.pop()
-	b := c.pop()
-	c.push(a)
-	c.push(b)
+func (c *cpu) iswap() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	if err := c.push(a); err != nil {
+		return err
+	}
+	return c.push(b)
 }
 
 // (a, b, c) -> (b, c, a)
-func (c *cpu) irol3() {
-	cc := c.pop()
-	b := c.pop()
-	a := c.pop()
-	c.push(b)
-	c.push(cc)
-	c.push(a)
-}
-
-// write stack top into stdin as number
-func (c *cpu) ioutnum() {
-	a := c.pop()
-	fmt.Printf("%d\n", a)
-	c.push(a)
-}
-
-// pop a, pop b, if a != 0 goto b
-func (c *cpu) ijnz() {
-	a := c.pop()
-	b := c.pop()
+func (c *cpu) irol3() error {
+	cc, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	a, err := c.pop()
+	if err != nil {
+		return err
+	}
+	for _, v := range []uint64{b, cc, a} {
+		if err := c.push(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write stack top into output device as number
+func (c *cpu) ioutnum() error {
+	a, err := c.pop()
+	if err != nil {
+		return err
+	}
+	num := fmt.Sprint(a)
+	if c.cfg.Signed {
+		num = fmt.Sprint(c.signed(a))
+	}
+	if _, err := fmt.Fprintf(c.out, "%s\n", num); err != nil {
+		return err
+	}
+	return c.push(a)
+}
+
+// pop a, pop b, if a != 0 goto b; before isa version 1
+// the target was offset by MemSize/2, a leftover of the
+// shared program and data memory
+func (c *cpu) ijnz() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
 	if a != 0 {
-		c.ip = int(b) + MemSize/2
+		c.ip = int(b)
 	}
+	return nil
 }
 
 // pop stack top
-func (c *cpu) idrop() {
-	c.pop()
+func (c *cpu) idrop() error {
+	_, err := c.pop()
+	return err
 }
 
 // push stack top complement
-func (c *cpu) icomp() {
-	c.push(-c.pop())
+func (c *cpu) icomp() error {
+	a, err := c.pop()
+	if err != nil {
+		return err
+	}
+	return c.pushres(-a&c.mask(), a != 0, a == c.signbit())
 }
 
 // increment counter
-func (c *cpu) icinc() {
-	c.cnt++
+func (c *cpu) icinc() error {
+	c.cnt = (c.cnt + 1) & c.mask()
+	return nil
 }
 
 // decrement counter
-func (c *cpu) icdec() {
-	c.cnt--
+func (c *cpu) icdec() error {
+	c.cnt = (c.cnt - 1) & c.mask()
+	return nil
 }
 
 // move value from counter to stack
-func (c *cpu) icts() {
-	c.push(c.cnt)
+func (c *cpu) icts() error {
+	return c.push(c.cnt)
 }
 
 // move value from stack to counter
-func (c *cpu) istc() {
-	c.cnt = c.pop()
+func (c *cpu) istc() error {
+	a, err := c.pop()
+	if err != nil {
+		return err
+	}
+	c.cnt = a
+	return nil
 }
 
 // terminate execution
-func (c *cpu) iterm() {
+func (c *cpu) iterm() error {
 	c.terminate()
+	return nil
 }
 
 // pop a, pop b, push a*b
-func (c *cpu) imul() {
-	c.push(c.pop() * c.pop())
+func (c *cpu) imul() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	hi, lo := bits.Mul64(a, b)
+	res := lo & c.mask()
+	wrapped := hi != 0 || lo != res
+	return c.pushres(res, wrapped, mulOverflows(c.signed(a), c.signed(b), c.signed(res)))
+}
+
+// pop a, push return address onto return stack, goto a
+func (c *cpu) icall() error {
+	a, err := c.pop()
+	if err != nil {
+		return err
+	}
+	if err := c.rpush(uint64(c.ip)); err != nil {
+		return err
+	}
+	c.ip = int(a)
+	return nil
+}
+
+// pop return address from return stack, goto there
+func (c *cpu) iret() error {
+	a, err := c.rpop()
+	if err != nil {
+		return err
+	}
+	c.ip = int(a)
+	return nil
+}
+
+// pop a, pop b, push b / a
+func (c *cpu) idiv() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	if a == 0 {
+		return FaultDivideByZero
+	}
+	if c.cfg.Signed {
+		return c.pushres(c.sdiv(b, a))
+	}
+	return c.pushres(b/a, false, false)
+}
+
+// pop a, pop b, push b % a
+func (c *cpu) imod() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	if a == 0 {
+		return FaultDivideByZero
+	}
+	if c.cfg.Signed {
+		// the remainder of the overflowing division is 0
+		return c.pushres(uint64(c.signed(b)%c.signed(a))&c.mask(), false, false)
+	}
+	return c.pushres(b%a, false, false)
+}
+
+// sdiv divides signed words truncating toward zero, the
+// most negative word divided by -1 overflows and stays as is.
+func (c *cpu) sdiv(b, a uint64) (uint64, bool, bool) {
+	sb, sa := c.signed(b), c.signed(a)
+	if b == c.signbit() && sa == -1 {
+		return b, false, true
+	}
+	return uint64(sb/sa) & c.mask(), false, false
+}
+
+// pop a, pop b, push b << a
+func (c *cpu) ishl() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	return c.pushres((b<<a)&c.mask(), false, false)
+}
+
+// pop a, pop b, push b >> a filling with zeroes
+func (c *cpu) ishr() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	return c.pushres(b>>a, false, false)
+}
+
+// pop a, pop b, push b >> a filling with the sign bit
+func (c *cpu) isar() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	return c.pushres(uint64(c.signed(b)>>a)&c.mask(), false, false)
+}
+
+// mulOverflows reports whether the signed product of a and b
+// differs from res, the product truncated to the word width.
+func mulOverflows(a, b, res int64) bool {
+	if a == 0 || b == 0 {
+		return false
+	}
+	if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return true
+	}
+	prod := a * b
+	if prod/b != a {
+		return true
+	}
+	return prod != res
+}
+
+func truth(cond bool) uint64 {
+	if cond {
+		return 1
+	}
+	return 0
+}
+
+// pop a, pop b, push 1 if b == a else 0
+func (c *cpu) ieq() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	return c.push(truth(b == a))
+}
+
+// pop a, pop b, push 1 if b < a as signed words else 0
+func (c *cpu) ilt() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	return c.push(truth(c.signed(b) < c.signed(a)))
+}
+
+// pop a, pop b, push 1 if b > a as signed words else 0
+func (c *cpu) igt() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	return c.push(truth(c.signed(b) > c.signed(a)))
+}
+
+// pop a, pop b, push 1 if b < a as unsigned words else 0
+func (c *cpu) iult() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	return c.push(truth(b < a))
+}
+
+// pop a, pop b, push 1 if b > a as unsigned words else 0
+func (c *cpu) iugt() error {
+	a, b, err := c.pop2()
+	if err != nil {
+		return err
+	}
+	return c.push(truth(b > a))
 }
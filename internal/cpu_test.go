@@ -1,23 +1,29 @@
 package internal
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
-func meminit(memory []int) []uint16 {
+func meminit(memory []int) []uint64 {
 	return sliceinit(memory, MemSize)
 }
 
-func stinit(stack []int) []uint16 {
+func stinit(stack []int) []uint64 {
 	return sliceinit(stack, StackLimit)
 }
 
-func sliceinit(content []int, size int) []uint16 {
-	sl := make([]uint16, size)
+func sliceinit(content []int, size int) []uint64 {
+	sl := make([]uint64, size)
 	for i, v := range content {
-		sl[i] = uint16(v)
+		sl[i] = uint64(v) & wordmask(defaultWordWidth)
 	}
 	return sl
 }
@@ -26,28 +32,35 @@ func eq(expected cpu, got cpu) (bool, error) {
 	if got.sp != expected.sp {
 		return false, fmt.Errorf(
 			"wrong stack pointer value: %d, expected: %d",
-			expected.sp, got.sp,
+			got.sp, expected.sp,
 		)
 	}
 
 	if got.ip != expected.ip {
 		return false, fmt.Errorf(
 			"wrong instruction pointer value: %d, expected: %d",
-			expected.ip, got.ip,
+			got.ip, expected.ip,
 		)
 	}
 
 	if !reflect.DeepEqual(expected.stack, got.stack) {
 		return false, fmt.Errorf(
 			"stacks are not equal: %v, expeced: %v",
-			expected.ip, got.ip,
+			got.stack, expected.stack,
 		)
 	}
 
 	if !reflect.DeepEqual(expected.program, got.program) {
 		return false, fmt.Errorf(
 			"memsets are not equal: %v, expeced: %v",
-			expected.ip, got.ip,
+			got.program, expected.program,
+		)
+	}
+
+	if !reflect.DeepEqual(expected.data, got.data) {
+		return false, fmt.Errorf(
+			"data memsets are not equal: %v, expeced: %v",
+			got.data, expected.data,
 		)
 	}
 
@@ -56,7 +69,7 @@ func eq(expected cpu, got cpu) (bool, error) {
 
 func TestCpu_push(t *testing.T) {
 	type args struct {
-		n uint16
+		n uint64
 	}
 	tests := []struct {
 		name string
@@ -68,12 +81,12 @@ func TestCpu_push(t *testing.T) {
 			name: "push should add value to a stack",
 			args: args{1},
 			c: cpu{
-				sp:      0,
+				sp:      -1,
 				program: meminit([]int{}),
 				stack:   stinit([]int{}),
 			},
 			want: cpu{
-				sp:      1,
+				sp:      0,
 				program: meminit([]int{}),
 				stack:   stinit([]int{1}),
 			},
@@ -96,24 +109,28 @@ func TestCpu_pop(t *testing.T) {
 		name  string
 		c     cpu
 		want  cpu
-		want1 uint16
+		want1 uint64
 	}{
 		{
 			name: "pop should return top value",
 			c: cpu{
-				sp:    1,
+				sp:    0,
 				stack: stinit([]int{1}),
 			},
 			want: cpu{
-				sp:    0,
-				stack: stinit([]int{1}),
+				sp:    -1,
+				stack: stinit([]int{}),
 			},
 			want1: 1,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.c.pop(); got != tt.want1 {
+			got, err := tt.c.pop()
+			if err != nil {
+				t.Fatalf("cpu.pop() error = %v", err)
+			}
+			if got != tt.want1 {
 				t.Errorf("cpu.pop() = %v, want %v", got, tt.want1)
 			}
 
@@ -134,11 +151,11 @@ func TestCpu_iadd(t *testing.T) {
 		{
 			name: "should pop two elements and push their sum",
 			c: cpu{
-				sp:    2,
+				sp:    1,
 				stack: stinit([]int{1, 2}),
 			},
 			want: cpu{
-				sp:    1,
+				sp:    0,
 				stack: stinit([]int{3, 0}),
 			},
 		},
@@ -164,11 +181,11 @@ func TestCpu_isub(t *testing.T) {
 		{
 			name: "should pop two elements from stack and push their difference",
 			c: cpu{
-				sp:    2,
+				sp:    1,
 				stack: stinit([]int{2, 1}),
 			},
 			want: cpu{
-				sp:    1,
+				sp:    0,
 				stack: stinit([]int{1, 0}),
 			},
 		},
@@ -194,11 +211,11 @@ func TestCpu_iand(t *testing.T) {
 		{
 			name: "should pop two elements from stack and push bitwise and",
 			c: cpu{
-				sp:    2,
+				sp:    1,
 				stack: stinit([]int{7, 5}),
 			},
 			want: cpu{
-				sp:    1,
+				sp:    0,
 				stack: stinit([]int{5, 0}),
 			},
 		},
@@ -224,11 +241,11 @@ func TestCpu_ior(t *testing.T) {
 		{
 			name: "should pop two elements from stack and push bitwise and",
 			c: cpu{
-				sp:    2,
+				sp:    1,
 				stack: stinit([]int{7, 5}),
 			},
 			want: cpu{
-				sp:    1,
+				sp:    0,
 				stack: stinit([]int{7, 0}),
 			},
 		},
@@ -254,11 +271,11 @@ func TestCpu_ixor(t *testing.T) {
 		{
 			name: "should pop two elements from stack and push bitwise xor",
 			c: cpu{
-				sp:    2,
+				sp:    1,
 				stack: stinit([]int{7, 5}),
 			},
 			want: cpu{
-				sp:    1,
+				sp:    0,
 				stack: stinit([]int{2, 0}),
 			},
 		},
@@ -284,11 +301,11 @@ func TestCpu_inot(t *testing.T) {
 		{
 			name: "should pop elements from stack and push bitwise not",
 			c: cpu{
-				sp:    1,
+				sp:    0,
 				stack: stinit([]int{2}),
 			},
 			want: cpu{
-				sp:    1,
+				sp:    0,
 				stack: stinit([]int{^2}),
 			},
 		},
@@ -314,14 +331,14 @@ func TestCpu_iload(t *testing.T) {
 		{
 			name: "should load value from memory onto the stack",
 			c: cpu{
-				sp:      1,
-				program: meminit([]int{5}),
-				stack:   stinit([]int{0}),
+				sp:    0,
+				data:  meminit([]int{5}),
+				stack: stinit([]int{0}),
 			},
 			want: cpu{
-				sp:      1,
-				program: meminit([]int{5}),
-				stack:   stinit([]int{5}),
+				sp:    0,
+				data:  meminit([]int{5}),
+				stack: stinit([]int{5}),
 			},
 		},
 	}
@@ -346,14 +363,14 @@ func TestCpu_istor(t *testing.T) {
 		{
 			name: "should store value from stack into memory",
 			c: cpu{
-				sp:      2,
-				stack:   stinit([]int{34, 1}),
-				program: meminit([]int{1, 2, 3}),
+				sp:    1,
+				stack: stinit([]int{34, 1}),
+				data:  meminit([]int{1, 2, 3}),
 			},
 			want: cpu{
-				sp:      0,
-				stack:   stinit([]int{34}),
-				program: meminit([]int{1, 34, 3}),
+				sp:    -1,
+				stack: stinit([]int{}),
+				data:  meminit([]int{1, 34, 3}),
 			},
 		},
 	}
@@ -378,14 +395,14 @@ func TestCpu_ijmp(t *testing.T) {
 		{
 			name: "should pop value from stack and goto there",
 			c: cpu{
-				sp:    1,
+				sp:    0,
 				ip:    0,
 				stack: stinit([]int{42}),
 			},
 			want: cpu{
-				sp:    0,
-				ip:    42 + MemSize/2,
-				stack: stinit([]int{42}),
+				sp:    -1,
+				ip:    42,
+				stack: stinit([]int{}),
 			},
 		},
 	}
@@ -410,27 +427,27 @@ func TestCpu_ijz(t *testing.T) {
 		{
 			name: "should pop value from stack and goto there",
 			c: cpu{
-				sp:    2,
+				sp:    1,
 				ip:    0,
 				stack: stinit([]int{42, 0}),
 			},
 			want: cpu{
-				sp:    0,
-				ip:    42 + MemSize/2,
-				stack: stinit([]int{42}),
+				sp:    -1,
+				ip:    42,
+				stack: stinit([]int{}),
 			},
 		},
 		{
 			name: "should pop value from stack and not goto there",
 			c: cpu{
-				sp:    2,
+				sp:    1,
 				ip:    0,
 				stack: stinit([]int{42, 1}),
 			},
 			want: cpu{
-				sp:    0,
+				sp:    -1,
 				ip:    0,
-				stack: stinit([]int{42}),
+				stack: stinit([]int{}),
 			},
 		},
 	}
@@ -455,13 +472,13 @@ func TestCpu_ipush(t *testing.T) {
 		{
 			name: "should push next word onto the stack",
 			c: cpu{
-				sp:      0,
+				sp:      -1,
 				ip:      0,
 				program: meminit([]int{42}),
 				stack:   stinit([]int{}),
 			},
 			want: cpu{
-				sp:      1,
+				sp:      0,
 				ip:      1,
 				program: meminit([]int{42}),
 				stack:   stinit([]int{42}),
@@ -489,11 +506,11 @@ func TestCpu_idup(t *testing.T) {
 		{
 			name: "should duplicate stack top",
 			c: cpu{
-				sp:    1,
+				sp:    0,
 				stack: stinit([]int{42}),
 			},
 			want: cpu{
-				sp:    2,
+				sp:    1,
 				stack: stinit([]int{42, 42}),
 			},
 		},
@@ -519,11 +536,11 @@ func TestCpu_iswap(t *testing.T) {
 		{
 			name: "should swap two top stack values",
 			c: cpu{
-				sp:    2,
+				sp:    1,
 				stack: stinit([]int{24, 42}),
 			},
 			want: cpu{
-				sp:    2,
+				sp:    1,
 				stack: stinit([]int{42, 24}),
 			},
 		},
@@ -549,11 +566,11 @@ func TestCpu_irol3(t *testing.T) {
 		{
 			name: "(a, b, c) -> (b, c, a)",
 			c: cpu{
-				sp:    3,
+				sp:    2,
 				stack: stinit([]int{24, 42, 86}),
 			},
 			want: cpu{
-				sp:    3,
+				sp:    2,
 				stack: stinit([]int{42, 86, 24}),
 			},
 		},
@@ -579,27 +596,27 @@ func TestCpu_ijnz(t *testing.T) {
 		{
 			name: "should pop value from stack and goto there",
 			c: cpu{
-				sp:    2,
+				sp:    1,
 				ip:    0,
 				stack: stinit([]int{42, 1}),
 			},
 			want: cpu{
-				sp:    0,
-				ip:    42 + MemSize/2,
-				stack: stinit([]int{42}),
+				sp:    -1,
+				ip:    42,
+				stack: stinit([]int{}),
 			},
 		},
 		{
 			name: "should pop value from stack and not goto there",
 			c: cpu{
-				sp:    2,
+				sp:    1,
 				ip:    0,
 				stack: stinit([]int{42, 0}),
 			},
 			want: cpu{
-				sp:    0,
+				sp:    -1,
 				ip:    0,
-				stack: stinit([]int{42}),
+				stack: stinit([]int{}),
 			},
 		},
 	}
@@ -624,12 +641,12 @@ func TestCpu_idrop(t *testing.T) {
 		{
 			name: "should drop stack top",
 			c: cpu{
-				sp:    1,
+				sp:    0,
 				stack: stinit([]int{42}),
 			},
 			want: cpu{
-				sp:    0,
-				stack: stinit([]int{42}),
+				sp:    -1,
+				stack: stinit([]int{}),
 			},
 		},
 	}
@@ -654,11 +671,11 @@ func TestCpu_icomp(t *testing.T) {
 		{
 			name: "should push top complement",
 			c: cpu{
-				sp:    1,
+				sp:    0,
 				stack: stinit([]int{42}),
 			},
 			want: cpu{
-				sp:    1,
+				sp:    0,
 				stack: stinit([]int{-42}),
 			},
 		},
@@ -679,20 +696,20 @@ func TestCpu_MemDump(t *testing.T) {
 	tests := []struct {
 		name string
 		c    cpu
-		want []uint16
+		want []uint64
 	}{
 		{
 			name: "should return copy of memory dump",
 			c: cpu{
-				program: []uint16{1, 2, 3},
+				program: meminit([]int{1, 2, 3}),
 			},
-			want: []uint16{1, 2, 3},
+			want: []uint64{1, 2, 3},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			ttwant := make([]uint16, MemSize)
+			ttwant := make([]uint64, MemSize)
 			copy(ttwant, tt.want)
 			tt.want = ttwant
 
@@ -702,3 +719,742 @@ func TestCpu_MemDump(t *testing.T) {
 		})
 	}
 }
+
+func TestCpu_Run(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []uint64
+		data    []uint64
+		kind    FaultKind
+		ip      int
+		stack   []uint64
+	}{
+		{
+			name:    "should stop on term without fault",
+			program: []uint64{PUSH, 1, TERM},
+			stack:   []uint64{1},
+		},
+		{
+			name:    "should fault on stack underflow",
+			program: []uint64{PUSH, 1, ADD, TERM},
+			kind:    FaultStackUnderflow,
+			ip:      2,
+			stack:   []uint64{1},
+		},
+		{
+			name:    "should report the stack before a partly executed instruction",
+			program: []uint64{PUSH, 1, PUSH, 2, ROL3, TERM},
+			kind:    FaultStackUnderflow,
+			ip:      4,
+			stack:   []uint64{1, 2},
+		},
+		{
+			name:    "should fault on stack overflow",
+			program: []uint64{PUSH, 7, DUP, PUSH, 0, JMP},
+			kind:    FaultStackOverflow,
+			ip:      3,
+			stack:   stinit([]int{7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7}),
+		},
+		{
+			name:    "should fault on unknown command",
+			program: []uint64{NOP, 0xFF},
+			kind:    FaultBadOpcode,
+			ip:      1,
+			stack:   []uint64{},
+		},
+		{
+			name:    "should fault on data address out of range",
+			program: []uint64{PUSH, uint64(MemSize), LOAD},
+			kind:    FaultDataAddress,
+			ip:      2,
+			stack:   []uint64{uint64(MemSize)},
+		},
+		{
+			name:    "should fault on fetch past program end",
+			program: []uint64{},
+			kind:    FaultFetch,
+			ip:      MemSize,
+			stack:   []uint64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := WithMemProg(tt.program, tt.data)
+			err := c.Run()
+
+			if tt.kind == 0 {
+				if err != nil {
+					t.Fatalf("cpu.Run() error = %v", err)
+				}
+				if got := c.StackDump()[:c.GetSp()+1]; !reflect.DeepEqual(got, tt.stack) {
+					t.Errorf("stack = %v, want %v", got, tt.stack)
+				}
+				return
+			}
+
+			var fault *Fault
+			if !errors.As(err, &fault) {
+				t.Fatalf("cpu.Run() error = %v, want *Fault", err)
+			}
+			if fault.Kind != tt.kind {
+				t.Errorf("fault kind = %v, want %v", fault.Kind, tt.kind)
+			}
+			if fault.IP != tt.ip {
+				t.Errorf("fault ip = %d, want %d", fault.IP, tt.ip)
+			}
+			if !reflect.DeepEqual(fault.Stack, tt.stack) {
+				t.Errorf("fault stack = %v, want %v", fault.Stack, tt.stack)
+			}
+			if err := c.Tick(); err != ErrHalted {
+				t.Errorf("cpu.Tick() after fault = %v, want %v", err, ErrHalted)
+			}
+		})
+	}
+}
+
+func TestCpu_RunContext(t *testing.T) {
+	loop := []uint64{PUSH, 0, JMP}
+
+	t.Run("should stop after max steps", func(t *testing.T) {
+		c := WithMemProg(loop, nil)
+		steps, err := c.RunContext(context.Background(), 10)
+		if err != ErrStepLimit {
+			t.Fatalf("cpu.RunContext() error = %v, want %v", err, ErrStepLimit)
+		}
+		if steps != 10 {
+			t.Errorf("cpu.RunContext() steps = %d, want 10", steps)
+		}
+	})
+
+	t.Run("should stop when context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		c := WithMemProg(loop, nil)
+		if _, err := c.RunContext(ctx, 0); err != context.DeadlineExceeded {
+			t.Fatalf("cpu.RunContext() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("should count steps until term", func(t *testing.T) {
+		c := WithMemProg([]uint64{PUSH, 1, DROP, TERM}, nil)
+		steps, err := c.RunContext(context.Background(), 0)
+		if err != nil {
+			t.Fatalf("cpu.RunContext() error = %v", err)
+		}
+		if steps != 3 {
+			t.Errorf("cpu.RunContext() steps = %d, want 3", steps)
+		}
+	})
+}
+
+func TestWithConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		program []uint64
+		data    []uint64
+		wantErr bool
+	}{
+		{
+			name:    "should create cpu with custom geometry",
+			cfg:     Config{ProgramWords: 4, DataWords: 200, StackDepth: 2, WordWidth: 16},
+			program: []uint64{PUSH, 1, TERM},
+			data:    make([]uint64, 200),
+		},
+		{
+			name:    "should reject program larger than program memory",
+			cfg:     Config{ProgramWords: 2, DataWords: 2, StackDepth: 2, WordWidth: 16},
+			program: []uint64{PUSH, 1, TERM},
+			wantErr: true,
+		},
+		{
+			name:    "should reject data larger than data memory",
+			cfg:     Config{ProgramWords: 4, DataWords: 2, StackDepth: 2, WordWidth: 16},
+			data:    []uint64{1, 2, 3},
+			wantErr: true,
+		},
+		{
+			name:    "should reject empty stack",
+			cfg:     Config{ProgramWords: 4, DataWords: 2, StackDepth: 0, WordWidth: 16},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := WithConfig(tt.cfg, tt.program, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WithConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got := len(c.MemDump()); got != tt.cfg.ProgramWords {
+				t.Errorf("len(MemDump()) = %d, want %d", got, tt.cfg.ProgramWords)
+			}
+			if got := len(c.DataDump()); got != tt.cfg.DataWords {
+				t.Errorf("len(DataDump()) = %d, want %d", got, tt.cfg.DataWords)
+			}
+			if got := len(c.StackDump()); got != tt.cfg.StackDepth {
+				t.Errorf("len(StackDump()) = %d, want %d", got, tt.cfg.StackDepth)
+			}
+		})
+	}
+}
+
+func TestCpu_io(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []uint64
+		input   string
+		want    string
+		wantErr error
+	}{
+		{
+			name:    "should echo input bytes",
+			program: []uint64{IN, OUT, IN, OUT, TERM},
+			input:   "ok",
+			want:    "ok",
+		},
+		{
+			name:    "should print stack top as number",
+			program: []uint64{IN, IN, ADD, OUTNUM, TERM},
+			input:   "\x01\x02",
+			want:    "3\n",
+		},
+		{
+			name:    "should fault when input is exhausted",
+			program: []uint64{IN, IN, TERM},
+			input:   "a",
+			wantErr: io.EOF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := bytes.Buffer{}
+			c := WithMemProg(tt.program, nil)
+			c.SetIO(strings.NewReader(tt.input), &out)
+
+			err := c.Run()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("cpu.Run() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCpu_icall(t *testing.T) {
+	c := cpu{
+		sp:     0,
+		ip:     5,
+		rsp:    -1,
+		stack:  stinit([]int{42}),
+		rstack: make([]uint64, ReturnLimit),
+	}
+
+	if err := c.icall(); err != nil {
+		t.Fatalf("cpu.icall() error = %v", err)
+	}
+
+	equal, problem := eq(cpu{sp: -1, ip: 42, stack: stinit([]int{})}, c)
+	if !equal {
+		t.Error(problem)
+	}
+	if c.rsp != 0 || c.rstack[0] != 5 {
+		t.Errorf("return stack = %v (rsp %d), want [5] (rsp 0)", c.rstack, c.rsp)
+	}
+}
+
+func TestCpu_iret(t *testing.T) {
+	c := cpu{
+		sp:     -1,
+		ip:     42,
+		rsp:    0,
+		stack:  stinit([]int{}),
+		rstack: []uint64{5, 0},
+	}
+
+	if err := c.iret(); err != nil {
+		t.Fatalf("cpu.iret() error = %v", err)
+	}
+
+	if c.ip != 5 {
+		t.Errorf("ip = %d, want 5", c.ip)
+	}
+	if c.rsp != -1 {
+		t.Errorf("rsp = %d, want -1", c.rsp)
+	}
+}
+
+func TestCpu_Run_call(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []uint64
+		depth   int
+		want    string
+		kind    FaultKind
+	}{
+		{
+			name: "should return to the instruction after call",
+			program: []uint64{
+				/* 00 */ PUSH, 7,
+				/* 02 */ CALL,
+				/* 03 */ PUSH, 7,
+				/* 05 */ CALL,
+				/* 06 */ TERM,
+				/* 07 */ PUSH, 1, OUTNUM, DROP, RET,
+			},
+			depth: 1,
+			want:  "1\n1\n",
+		},
+		{
+			name:    "should fault on return stack overflow",
+			program: []uint64{PUSH, 0, CALL},
+			depth:   4,
+			kind:    FaultReturnOverflow,
+		},
+		{
+			name:    "should fault on return stack underflow",
+			program: []uint64{RET},
+			depth:   4,
+			kind:    FaultReturnUnderflow,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.ReturnDepth = tt.depth
+
+			c, err := WithConfig(cfg, tt.program, nil)
+			if err != nil {
+				t.Fatalf("WithConfig() error = %v", err)
+			}
+			out := bytes.Buffer{}
+			c.SetIO(strings.NewReader(""), &out)
+
+			err = c.Run()
+
+			var fault *Fault
+			if errors.As(err, &fault) {
+				if fault.Kind != tt.kind {
+					t.Errorf("fault kind = %v, want %v", fault.Kind, tt.kind)
+				}
+			} else if err != nil || tt.kind != 0 {
+				t.Fatalf("cpu.Run() error = %v, want %v", err, tt.kind)
+			}
+
+			if got := out.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCpu_idiv(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       cpu
+		want    cpu
+		wantErr error
+	}{
+		{
+			name: "should pop two elements and push their quotient",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{7, 2}),
+			},
+			want: cpu{
+				sp:    0,
+				stack: stinit([]int{3}),
+			},
+		},
+		{
+			name: "should fault on division by zero",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{7, 0}),
+			},
+			want: cpu{
+				sp:    -1,
+				stack: stinit([]int{}),
+			},
+			wantErr: FaultDivideByZero,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.idiv(); err != tt.wantErr {
+				t.Fatalf("cpu.idiv() error = %v, want %v", err, tt.wantErr)
+			}
+
+			equal, problem := eq(tt.want, tt.c)
+			if !equal {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+func TestCpu_imod(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       cpu
+		want    cpu
+		wantErr error
+	}{
+		{
+			name: "should pop two elements and push remainder",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{7, 2}),
+			},
+			want: cpu{
+				sp:    0,
+				stack: stinit([]int{1}),
+			},
+		},
+		{
+			name: "should fault on division by zero",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{7, 0}),
+			},
+			want: cpu{
+				sp:    -1,
+				stack: stinit([]int{}),
+			},
+			wantErr: FaultDivideByZero,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.imod(); err != tt.wantErr {
+				t.Fatalf("cpu.imod() error = %v, want %v", err, tt.wantErr)
+			}
+
+			equal, problem := eq(tt.want, tt.c)
+			if !equal {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+func TestCpu_ishl(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       cpu
+		want    cpu
+		wantErr error
+	}{
+		{
+			name: "should shift left",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{3, 4}),
+			},
+			want: cpu{
+				sp:    0,
+				stack: stinit([]int{48}),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.ishl(); err != tt.wantErr {
+				t.Fatalf("cpu.ishl() error = %v, want %v", err, tt.wantErr)
+			}
+
+			equal, problem := eq(tt.want, tt.c)
+			if !equal {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+func TestCpu_ishr(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       cpu
+		want    cpu
+		wantErr error
+	}{
+		{
+			name: "should shift right filling with zeroes",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{0x8000, 15}),
+			},
+			want: cpu{
+				sp:    0,
+				stack: stinit([]int{1}),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.ishr(); err != tt.wantErr {
+				t.Fatalf("cpu.ishr() error = %v, want %v", err, tt.wantErr)
+			}
+
+			equal, problem := eq(tt.want, tt.c)
+			if !equal {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+func TestCpu_isar(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       cpu
+		want    cpu
+		wantErr error
+	}{
+		{
+			name: "should shift right filling with sign bit",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{0x8000, 15}),
+			},
+			want: cpu{
+				sp:    0,
+				stack: stinit([]int{0xFFFF}),
+			},
+		},
+		{
+			name: "should shift positive values like shr",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{0x4000, 14}),
+			},
+			want: cpu{
+				sp:    0,
+				stack: stinit([]int{1}),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.isar(); err != tt.wantErr {
+				t.Fatalf("cpu.isar() error = %v, want %v", err, tt.wantErr)
+			}
+
+			equal, problem := eq(tt.want, tt.c)
+			if !equal {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+func TestCpu_ieq(t *testing.T) {
+	tests := []struct {
+		name string
+		c    cpu
+		want cpu
+	}{
+		{
+			name: "should push 1 for equal values",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{5, 5}),
+			},
+			want: cpu{
+				sp:    0,
+				stack: stinit([]int{1}),
+			},
+		},
+		{
+			name: "should push 0 for different values",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{5, 6}),
+			},
+			want: cpu{
+				sp:    0,
+				stack: stinit([]int{0}),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.c.ieq()
+
+			equal, problem := eq(tt.want, tt.c)
+			if !equal {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+func TestCpu_ilt(t *testing.T) {
+	tests := []struct {
+		name string
+		c    cpu
+		want cpu
+	}{
+		{
+			name: "should compare as signed words",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{0xFFFF, 1}),
+			},
+			want: cpu{
+				sp:    0,
+				stack: stinit([]int{1}),
+			},
+		},
+		{
+			name: "should push 0 when not less",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{1, 1}),
+			},
+			want: cpu{
+				sp:    0,
+				stack: stinit([]int{0}),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.c.ilt()
+
+			equal, problem := eq(tt.want, tt.c)
+			if !equal {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+func TestCpu_igt(t *testing.T) {
+	tests := []struct {
+		name string
+		c    cpu
+		want cpu
+	}{
+		{
+			name: "should compare as signed words",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{1, 0xFFFF}),
+			},
+			want: cpu{
+				sp:    0,
+				stack: stinit([]int{1}),
+			},
+		},
+		{
+			name: "should push 0 when not greater",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{0xFFFF, 1}),
+			},
+			want: cpu{
+				sp:    0,
+				stack: stinit([]int{0}),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.c.igt()
+
+			equal, problem := eq(tt.want, tt.c)
+			if !equal {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+func TestCpu_iult(t *testing.T) {
+	tests := []struct {
+		name string
+		c    cpu
+		want cpu
+	}{
+		{
+			name: "should compare as unsigned words",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{1, 0xFFFF}),
+			},
+			want: cpu{
+				sp:    0,
+				stack: stinit([]int{1}),
+			},
+		},
+		{
+			name: "should push 0 when not less",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{0xFFFF, 1}),
+			},
+			want: cpu{
+				sp:    0,
+				stack: stinit([]int{0}),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.c.iult()
+
+			equal, problem := eq(tt.want, tt.c)
+			if !equal {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+func TestCpu_iugt(t *testing.T) {
+	tests := []struct {
+		name string
+		c    cpu
+		want cpu
+	}{
+		{
+			name: "should compare as unsigned words",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{0xFFFF, 1}),
+			},
+			want: cpu{
+				sp:    0,
+				stack: stinit([]int{1}),
+			},
+		},
+		{
+			name: "should push 0 when not greater",
+			c: cpu{
+				sp:    1,
+				stack: stinit([]int{1, 1}),
+			},
+			want: cpu{
+				sp:    0,
+				stack: stinit([]int{0}),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.c.iugt()
+
+			equal, problem := eq(tt.want, tt.c)
+			if !equal {
+				t.Error(problem)
+			}
+		})
+	}
+}
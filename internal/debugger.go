@@ -0,0 +1,577 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const debuggerPrompt = "(sm) "
+
+// debuggerHistory is how many instructions the debugger can undo.
+const debuggerHistory = 1 << 20
+
+const debuggerHelp = `commands:
+  break [addr|label|* [if top|counter|steps op value]]
+                          set a breakpoint, * checks the condition after
+                          every instruction, list breakpoints without argument
+  delete addr|label|*     remove breakpoints at the address
+  watch [addr|label [read|write|access]]
+                          stop when a data word is written (by default),
+                          read or both, list watchpoints without argument
+  unwatch addr|label      remove a watchpoint
+  step [n]                execute n instructions, 1 by default
+  next                    like step, but runs called routines to their return
+  continue                run until a breakpoint, TERM or a fault
+  back [n]                undo n instructions, 1 by default
+  rewind step             go back to the given step number
+  lastwrite addr|label    go back to the last instruction that wrote
+                          to the data word
+  where                   show the instruction at the instruction pointer
+  print stack|rstack|counter|flags|ip|all
+  print data [addr|label [n]]
+                          show machine state, n data words from addr
+  set addr|label value    write value to data memory
+  set counter|ip value    change a register, set drops the history
+                          used by back, rewind and lastwrite
+  help                    show this message
+  quit                    leave the debugger
+`
+
+// Debugger runs a program instruction by instruction on
+// behalf of commands read from the user, see debuggerHelp.
+type Debugger struct {
+	cpu *cpu
+	img *Image
+	dis *disassembler
+	out io.Writer
+}
+
+func NewDebugger(c *cpu, img *Image) *Debugger {
+	names := make(map[uint64]string)
+	for _, sym := range img.Symbols {
+		if sym.Section == SectionCode {
+			names[sym.Addr] = sym.Name
+		}
+	}
+
+	c.EnableHistory(debuggerHistory)
+	return &Debugger{
+		cpu: c,
+		img: img,
+		dis: newdisassembler(c.MemDump(), names),
+	}
+}
+
+// Repl reads commands from in until quit or end of
+// input and writes their results to out.
+func (d *Debugger) Repl(in io.Reader, out io.Writer) error {
+	d.out = out
+	d.where()
+
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, debuggerPrompt)
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" || fields[0] == "q" {
+			return nil
+		}
+		if err := d.exec(fields[0], fields[1:]); err != nil {
+			fmt.Fprintf(out, "error: %v\n", err)
+		}
+	}
+}
+
+func (d *Debugger) exec(cmd string, args []string) error {
+	switch cmd {
+	case "break", "b":
+		return d.setbreak(args)
+
+	case "delete", "d":
+		if len(args) != 1 {
+			return fmt.Errorf("delete expects an address or a label")
+		}
+		addr, err := d.breakaddr(args[0])
+		if err != nil {
+			return err
+		}
+		if !d.cpu.ClearBreakpoints(addr) {
+			return fmt.Errorf("no breakpoint at %s", d.breaklocation(addr))
+		}
+
+	case "watch":
+		return d.watch(args)
+
+	case "unwatch":
+		if len(args) != 1 {
+			return fmt.Errorf("unwatch expects an address or a label")
+		}
+		addr, err := d.dataaddr(args[0])
+		if err != nil {
+			return err
+		}
+		if !d.cpu.Unwatch(addr) {
+			return fmt.Errorf("no watchpoint at %s", d.datalocation(addr))
+		}
+
+	case "step", "s":
+		n := 1
+		if len(args) > 0 {
+			v, err := strconv.Atoi(args[0])
+			if err != nil || v <= 0 {
+				return fmt.Errorf("malformed step count '%s'", args[0])
+			}
+			n = v
+		}
+		for i := 0; i < n && d.tick(); i++ {
+			// tick reports why the program stopped
+		}
+		d.where()
+
+	case "next", "n":
+		d.next()
+
+	case "continue", "c":
+		d.cont()
+
+	case "back":
+		n := 1
+		if len(args) > 0 {
+			v, err := strconv.Atoi(args[0])
+			if err != nil || v <= 0 {
+				return fmt.Errorf("malformed step count '%s'", args[0])
+			}
+			n = v
+		}
+		var err error
+		for i := 0; i < n && err == nil; i++ {
+			err = d.cpu.StepBack()
+		}
+		d.backwhere()
+		return err
+
+	case "rewind":
+		if len(args) != 1 {
+			return fmt.Errorf("rewind expects a step number")
+		}
+		step, err := strconv.Atoi(args[0])
+		if err != nil || step < 0 {
+			return fmt.Errorf("malformed step number '%s'", args[0])
+		}
+		if err := d.cpu.RewindTo(step); err != nil {
+			return err
+		}
+		d.backwhere()
+
+	case "lastwrite":
+		if len(args) != 1 {
+			return fmt.Errorf("lastwrite expects an address or a label")
+		}
+		addr, err := d.dataaddr(args[0])
+		if err != nil {
+			return err
+		}
+		if err := d.cpu.BackToWrite(addr); err != nil {
+			return err
+		}
+		d.backwhere()
+
+	case "where", "w":
+		d.where()
+
+	case "print", "p":
+		return d.print(args)
+
+	case "set":
+		return d.set(args)
+
+	case "help", "h":
+		fmt.Fprint(d.out, debuggerHelp)
+
+	default:
+		return fmt.Errorf("unknown command '%s', try help", cmd)
+	}
+	return nil
+}
+
+// tick executes an instruction and tells if the program
+// may go on, hit breakpoints and watchpoints are reported.
+func (d *Debugger) tick() bool {
+	if !d.cpu.running {
+		fmt.Fprintln(d.out, "program is not running")
+		return false
+	}
+
+	err := d.cpu.tick()
+	if brk, ok := err.(*Break); ok {
+		for _, e := range brk.Events {
+			d.report(e)
+		}
+		return false
+	}
+	if err != nil {
+		fmt.Fprintf(d.out, "program stopped: %v (after %d steps)\n", err, d.cpu.Steps())
+		return false
+	}
+	if !d.cpu.running {
+		fmt.Fprintf(d.out, "program terminated after %d steps\n", d.cpu.Steps())
+		return false
+	}
+	return true
+}
+
+func (d *Debugger) report(e Event) {
+	switch e.Kind {
+	case EventBreak:
+		fmt.Fprintf(d.out, "breakpoint at %s", d.location(e.IP))
+		if e.Cond != nil {
+			fmt.Fprintf(d.out, " if %s", e.Cond)
+		}
+		fmt.Fprintln(d.out)
+	case EventRead:
+		fmt.Fprintf(d.out, "data %s read by %s: %d\n",
+			d.datalocation(e.Addr), d.location(e.IP), e.Old)
+	case EventWrite:
+		fmt.Fprintf(d.out, "data %s written by %s: %d -> %d\n",
+			d.datalocation(e.Addr), d.location(e.IP), e.Old, e.New)
+	}
+}
+
+// cont runs until a breakpoint or a watchpoint
+// is hit or the program stops.
+func (d *Debugger) cont() {
+	for d.tick() {
+		// tick reports why the program stopped
+	}
+	d.where()
+}
+
+// next steps over CALL by running until the
+// routine returns or a breakpoint is hit.
+func (d *Debugger) next() {
+	ip := d.cpu.ip
+	if ip < 0 || ip >= len(d.cpu.program) || d.cpu.program[ip] != CALL {
+		d.tick()
+		d.where()
+		return
+	}
+
+	depth := d.cpu.rsp
+	if !d.tick() {
+		d.where()
+		return
+	}
+	for d.cpu.rsp != depth {
+		if !d.tick() {
+			break
+		}
+	}
+	d.where()
+}
+
+// backwhere tells where going back has stopped.
+func (d *Debugger) backwhere() {
+	fmt.Fprintf(d.out, "at step %d\n", d.cpu.Steps())
+	d.where()
+}
+
+func (d *Debugger) where() {
+	if !d.cpu.running {
+		return
+	}
+	fmt.Fprintf(d.out, "%s  %s\n", d.location(d.cpu.ip), d.instruction(d.cpu.ip))
+}
+
+// instruction disassembles the instruction at addr
+// together with its operand.
+func (d *Debugger) instruction(addr int) string {
+	if addr < 0 || addr >= len(d.dis.program) {
+		return "<outside of program memory>"
+	}
+	ins := d.dis.word(addr)
+	if d.dis.program[addr] != PUSH || d.dis.operand[addr] || addr+1 >= len(d.dis.program) {
+		return ins
+	}
+	return ins + " " + d.dis.word(addr+1)
+}
+
+// location prints address together with the closest
+// code symbol before it, e.g. 0x0005 <loop+2>.
+func (d *Debugger) location(addr int) string {
+	best := Symbol{}
+	found := false
+	for _, sym := range d.img.Symbols {
+		if sym.Section != SectionCode || sym.Addr > uint64(addr) {
+			continue
+		}
+		if !found || sym.Addr > best.Addr {
+			best, found = sym, true
+		}
+	}
+
+	if !found {
+		return fmt.Sprintf("%#04x", addr)
+	}
+	if best.Addr == uint64(addr) {
+		return fmt.Sprintf("%#04x <%s>", addr, best.Name)
+	}
+	return fmt.Sprintf("%#04x <%s+%d>", addr, best.Name, uint64(addr)-best.Addr)
+}
+
+// datalocation prints data address together with
+// the data symbol at it, e.g. 0x0000 <total>.
+func (d *Debugger) datalocation(addr int) string {
+	for _, sym := range d.img.Symbols {
+		if sym.Section == SectionData && sym.Addr == uint64(addr) {
+			return fmt.Sprintf("%#04x <%s>", addr, sym.Name)
+		}
+	}
+	return fmt.Sprintf("%#04x", addr)
+}
+
+func (d *Debugger) setbreak(args []string) error {
+	if len(args) == 0 {
+		bps := d.cpu.Breakpoints()
+		if len(bps) == 0 {
+			fmt.Fprintln(d.out, "no breakpoints")
+		}
+		for _, bp := range bps {
+			d.printbreak(bp)
+		}
+		return nil
+	}
+
+	addr, err := d.breakaddr(args[0])
+	if err != nil {
+		return err
+	}
+	bp := Breakpoint{Addr: addr}
+	if len(args) > 1 {
+		if args[1] != "if" {
+			return fmt.Errorf("expected 'if' and a condition after the address")
+		}
+		cond, err := ParseCondition(args[2:])
+		if err != nil {
+			return err
+		}
+		bp.Cond = &cond
+	}
+	if addr == AnyAddress && bp.Cond == nil {
+		return fmt.Errorf("breakpoint at any address needs a condition")
+	}
+
+	d.cpu.SetBreakpoint(bp)
+	d.printbreak(bp)
+	return nil
+}
+
+func (d *Debugger) breaklocation(addr int) string {
+	if addr == AnyAddress {
+		return "any address"
+	}
+	return d.location(addr)
+}
+
+func (d *Debugger) printbreak(bp Breakpoint) {
+	fmt.Fprintf(d.out, "breakpoint at %s", d.breaklocation(bp.Addr))
+	if bp.Cond != nil {
+		fmt.Fprintf(d.out, " if %s", bp.Cond)
+	}
+	fmt.Fprintln(d.out)
+}
+
+func (d *Debugger) breakaddr(s string) (int, error) {
+	if s == "*" {
+		return AnyAddress, nil
+	}
+	return d.codeaddr(s)
+}
+
+func (d *Debugger) watch(args []string) error {
+	if len(args) == 0 {
+		ws := d.cpu.Watchpoints()
+		if len(ws) == 0 {
+			fmt.Fprintln(d.out, "no watchpoints")
+		}
+		for _, w := range ws {
+			d.printwatch(w)
+		}
+		return nil
+	}
+
+	addr, err := d.dataaddr(args[0])
+	if err != nil {
+		return err
+	}
+	w := Watchpoint{Addr: addr, Write: true}
+	if len(args) > 1 {
+		switch args[1] {
+		case "read":
+			w = Watchpoint{Addr: addr, Read: true}
+		case "write":
+		case "access":
+			w.Read = true
+		default:
+			return fmt.Errorf("unknown watch mode '%s', expected read, write or access", args[1])
+		}
+	}
+
+	d.cpu.Watch(w)
+	d.printwatch(w)
+	return nil
+}
+
+func (d *Debugger) printwatch(w Watchpoint) {
+	mode := "write"
+	if w.Read && w.Write {
+		mode = "access"
+	} else if w.Read {
+		mode = "read"
+	}
+	fmt.Fprintf(d.out, "watchpoint on %s of data %s\n", mode, d.datalocation(w.Addr))
+}
+
+func (d *Debugger) print(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("print expects what to show, try help")
+	}
+
+	digits := d.cpu.cfg.width() / 4
+	switch args[0] {
+	case "stack":
+		fmt.Fprintf(d.out, "stack: %s\n", d.words(d.cpu.stack[:d.cpu.sp+1]))
+	case "rstack":
+		fmt.Fprintf(d.out, "return stack: %s\n", d.words(d.cpu.rstack[:d.cpu.rsp+1]))
+	case "counter":
+		fmt.Fprintf(d.out, "counter: %d\n", d.cpu.cnt)
+	case "flags":
+		fmt.Fprintf(d.out, "flags: %s\n", formatFlags(d.cpu.flags))
+	case "ip":
+		fmt.Fprintf(d.out, "ip: %s\n", d.location(d.cpu.ip))
+	case "all":
+		fmt.Fprint(d.out, d.cpu.Dump())
+	case "data":
+		if len(args) == 1 {
+			fmt.Fprint(d.out, dtable(d.cpu.data, digits))
+			return nil
+		}
+		addr, err := d.dataaddr(args[1])
+		if err != nil {
+			return err
+		}
+		n := 1
+		if len(args) > 2 {
+			if n, err = strconv.Atoi(args[2]); err != nil || n <= 0 {
+				return fmt.Errorf("malformed word count '%s'", args[2])
+			}
+		}
+		if addr+n > len(d.cpu.data) {
+			n = len(d.cpu.data) - addr
+		}
+		for i := addr; i < addr+n; i++ {
+			fmt.Fprintf(d.out, "%#04x: %d (%s)\n", i, d.cpu.data[i], formatNumber(d.cpu.data[i], digits))
+		}
+	default:
+		return fmt.Errorf("can not print '%s', try help", args[0])
+	}
+	return nil
+}
+
+// words prints stack contents, top of the stack is last.
+func (d *Debugger) words(words []uint64) string {
+	strs := make([]string, 0, len(words))
+	for _, w := range words {
+		strs = append(strs, strconv.FormatUint(w, 10))
+	}
+	return "[" + strings.Join(strs, " ") + "]"
+}
+
+// set changes the machine outside of any instruction, so
+// the history is dropped, going back would restore a state
+// the program never had.
+func (d *Debugger) set(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("set expects a target and a value")
+	}
+
+	if args[0] == "ip" {
+		addr, err := d.codeaddr(args[1])
+		if err != nil {
+			return err
+		}
+		d.cpu.ip = addr
+		d.cpu.ClearHistory()
+		d.where()
+		return nil
+	}
+
+	val, err := d.value(args[1])
+	if err != nil {
+		return err
+	}
+	if args[0] == "counter" {
+		d.cpu.cnt = val
+		d.cpu.ClearHistory()
+		return nil
+	}
+
+	addr, err := d.dataaddr(args[0])
+	if err != nil {
+		return err
+	}
+	d.cpu.data[addr] = val
+	d.cpu.ClearHistory()
+	return nil
+}
+
+// value parses a number and checks it fits into the word width,
+// negative numbers are stored in two's complement.
+func (d *Debugger) value(s string) (uint64, error) {
+	width := d.cpu.cfg.width()
+	if strings.HasPrefix(s, "-") {
+		v, err := strconv.ParseInt(s, 0, width)
+		if err != nil {
+			return 0, fmt.Errorf("malformed value '%s'", s)
+		}
+		return uint64(v) & wordmask(width), nil
+	}
+	v, err := strconv.ParseUint(s, 0, width)
+	if err != nil {
+		return 0, fmt.Errorf("malformed value '%s'", s)
+	}
+	return v, nil
+}
+
+// address parses a number or a label of the given section.
+func (d *Debugger) address(s string, section int, size int) (int, error) {
+	addr, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		sym, ok := d.img.Lookup(s)
+		if !ok || sym.Section != section {
+			return 0, fmt.Errorf("unknown label '%s'", s)
+		}
+		addr = sym.Addr
+	}
+	if addr >= uint64(size) {
+		return 0, fmt.Errorf("address %#04x is outside of %d words of memory", addr, size)
+	}
+	return int(addr), nil
+}
+
+func (d *Debugger) codeaddr(s string) (int, error) {
+	return d.address(s, SectionCode, len(d.cpu.program))
+}
+
+func (d *Debugger) dataaddr(s string) (int, error) {
+	return d.address(s, SectionData, len(d.cpu.data))
+}
@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const debuggee = `
+main:   nop
+        push 3
+        stc
+loop:   push &sum
+        call
+        cdec
+        cts
+        push &loop
+        swap
+        jnz
+        term
+sum:    push &total
+        load
+        push 2
+        add
+        push &total
+        stor
+        ret
+.data
+total:  .word 0`
+
+func debug(t *testing.T, src string, script string) string {
+	t.Helper()
+
+	img, err := assembleString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu, err := FromImage(DefaultConfig(), img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu.SetIO(strings.NewReader(""), &bytes.Buffer{})
+
+	out := bytes.Buffer{}
+	if err := NewDebugger(cpu, img).Repl(strings.NewReader(script), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestDebugger_session(t *testing.T) {
+	script := `break sum
+continue
+print stack
+print rstack
+step 2
+print data total
+continue
+delete sum
+next
+set total 10
+print data total
+continue
+`
+	want := `0x0000 <main>  nop
+(sm) breakpoint at 0x000e <sum>
+(sm) breakpoint at 0x000e <sum>
+0x000e <sum>  push 0
+(sm) stack: []
+(sm) return stack: [7]
+(sm) 0x0011 <sum+3>  push 2
+(sm) 0x0000: 0 (0x0000)
+(sm) breakpoint at 0x000e <sum>
+0x000e <sum>  push 0
+(sm) (sm) 0x0010 <sum+2>  load
+(sm) (sm) 0x0000: 10 (0x000a)
+(sm) program terminated after 46 steps
+(sm) 
+`
+	if got := debug(t, debuggee, script); got != want {
+		t.Errorf("session =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDebugger_next(t *testing.T) {
+	script := "step 4\nnext\nprint data total\n"
+	want := "0x0000 <main>  nop\n" +
+		"(sm) 0x0006 <loop+2>  call\n" +
+		"(sm) 0x0007 <loop+3>  cdec\n" +
+		"(sm) 0x0000: 2 (0x0002)\n" +
+		"(sm) \n"
+	if got := debug(t, debuggee, script); got != want {
+		t.Errorf("session =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDebugger_errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{name: "unknown command", script: "frob", want: "unknown command 'frob'"},
+		{name: "unknown label", script: "break nope", want: "unknown label 'nope'"},
+		{name: "data label as breakpoint", script: "break total", want: "unknown label 'total'"},
+		{name: "address outside of memory", script: "break 1000", want: "outside of 80 words"},
+		{name: "no breakpoint", script: "delete 3", want: "no breakpoint at 0x0003"},
+		{name: "value does not fit", script: "set total 70000", want: "malformed value '70000'"},
+		{name: "bad step count", script: "step x", want: "malformed step count 'x'"},
+		{name: "stepping a halted program", script: "continue\nstep", want: "program is not running"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := debug(t, debuggee, tt.script)
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("session =\n%s\nshould contain %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDebugger_fault(t *testing.T) {
+	got := debug(t, "main: add", "continue\n")
+	if !strings.Contains(got, "program stopped: stack underflow at 0x0000") {
+		t.Errorf("session =\n%s\nshould report the fault", got)
+	}
+}
+
+func TestDebugger_watch(t *testing.T) {
+	script := "watch total\nwatch\ncontinue\nunwatch total\nbreak sum if counter == 1\ncontinue\nprint counter\n"
+	want := "0x0000 <main>  nop\n" +
+		"(sm) watchpoint on write of data 0x0000 <total>\n" +
+		"(sm) watchpoint on write of data 0x0000 <total>\n" +
+		"(sm) data 0x0000 <total> written by 0x0016 <sum+8>: 0 -> 2\n" +
+		"0x0017 <sum+9>  ret\n" +
+		"(sm) (sm) breakpoint at 0x000e <sum> if counter == 1\n" +
+		"(sm) breakpoint at 0x000e <sum> if counter == 1\n" +
+		"0x000e <sum>  push 0\n" +
+		"(sm) counter: 1\n" +
+		"(sm) \n"
+	if got := debug(t, debuggee, script); got != want {
+		t.Errorf("session =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDebugger_reverse(t *testing.T) {
+	script := `continue
+lastwrite total
+print data total
+back 2
+rewind 1
+rewind 100
+continue
+`
+	want := `0x0000 <main>  nop
+(sm) program terminated after 46 steps
+(sm) at step 38
+0x0016 <sum+8>  stor
+(sm) 0x0000: 4 (0x0004)
+(sm) at step 36
+0x0013 <sum+5>  add
+(sm) at step 1
+0x0001 <main+1>  push 3
+(sm) error: can not rewind forward to step 100, at step 1
+(sm) program terminated after 46 steps
+(sm) 
+`
+	if got := debug(t, debuggee, script); got != want {
+		t.Errorf("session =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDebugger_setClearsHistory(t *testing.T) {
+	script := `step 3
+set total 5
+back
+set counter 1
+continue
+print data total
+`
+	want := `0x0000 <main>  nop
+(sm) 0x0004 <loop>  push &sum
+(sm) (sm) at step 3
+0x0004 <loop>  push &sum
+error: no more history
+(sm) (sm) program terminated after 18 steps
+(sm) 0x0000: 7 (0x0007)
+(sm) 
+`
+	if got := debug(t, debuggee, script); got != want {
+		t.Errorf("session =\n%s\nwant\n%s", got, want)
+	}
+}
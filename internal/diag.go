@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Pos is a position in assembler source, lines
+// and columns are counted from 1. Positions inside
+// of a macro body know where the macro was invoked.
+type Pos struct {
+	File string
+	Line int
+	Col  int
+
+	exp *expansion
+}
+
+// anchor is the outermost macro invocation that
+// produced the position, or the position itself.
+func (p Pos) anchor() Pos {
+	for p.exp != nil {
+		p = p.exp.call
+	}
+	return p
+}
+
+func (p Pos) String() string {
+	file := p.File
+	if file == "" {
+		file = "<input>"
+	}
+	return fmt.Sprintf("%s:%d:%d", file, p.Line, p.Col)
+}
+
+func (p Pos) before(o Pos) bool {
+	if p.File != o.File {
+		return p.File < o.File
+	}
+	if p.Line != o.Line {
+		return p.Line < o.Line
+	}
+	return p.Col < o.Col
+}
+
+// Diagnostic is a single assembler error with the
+// source line it was found on. Notes point at macro
+// invocations the error came from.
+type Diagnostic struct {
+	Pos     Pos
+	Msg     string
+	Excerpt string
+	Notes   []Diagnostic
+}
+
+func (d Diagnostic) Error() string {
+	res := d.format("error")
+	for _, n := range d.Notes {
+		res += "\n" + n.format("note")
+	}
+	return res
+}
+
+func (d Diagnostic) format(kind string) string {
+	res := fmt.Sprintf("%s: %s: %s", d.Pos, kind, d.Msg)
+	if d.Excerpt == "" {
+		return res
+	}
+	return res + "\n" + d.Excerpt + "\n" + caret(d.Excerpt, d.Pos.Col)
+}
+
+// caret points at col of line, tabs are kept so
+// the caret lines up in terminals with any tab width.
+func caret(line string, col int) string {
+	sb := strings.Builder{}
+	for i, r := range []rune(line) {
+		if i >= col-1 {
+			break
+		}
+		if r == '\t' {
+			sb.WriteRune('\t')
+		} else {
+			sb.WriteRune(' ')
+		}
+	}
+	sb.WriteRune('^')
+	return sb.String()
+}
+
+// Diagnostics is returned by the assembler
+// when source has one or more errors.
+type Diagnostics []Diagnostic
+
+func (ds Diagnostics) Error() string {
+	msgs := make([]string, 0, len(ds))
+	for _, d := range ds {
+		msgs = append(msgs, d.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// reporter collects diagnostics and keeps source
+// lines around to attach excerpts to them.
+type reporter struct {
+	diags   Diagnostics
+	sources map[string][]string
+}
+
+func newreporter() *reporter {
+	return &reporter{
+		sources: make(map[string][]string),
+	}
+}
+
+func (r *reporter) addsource(file string, src string) {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	r.sources[file] = strings.Split(src, "\n")
+}
+
+func (r *reporter) errorf(pos Pos, format string, args ...interface{}) {
+	d := Diagnostic{
+		Pos: pos,
+		Msg: fmt.Sprintf(format, args...),
+	}
+	for e := pos.exp; e != nil; e = e.parent {
+		d.Notes = append(d.Notes, Diagnostic{
+			Pos: e.call,
+			Msg: fmt.Sprintf("in expansion of macro '%s'", e.macro),
+		})
+	}
+	r.diags = append(r.diags, d)
+}
+
+func (r *reporter) excerpt(pos Pos) string {
+	lines, ok := r.sources[pos.File]
+	if ok && 0 < pos.Line && pos.Line <= len(lines) {
+		return lines[pos.Line-1]
+	}
+	return ""
+}
+
+// err attaches excerpts to collected diagnostics, sources
+// may be added after the errors were reported.
+func (r *reporter) err() error {
+	if len(r.diags) == 0 {
+		return nil
+	}
+	for i, d := range r.diags {
+		r.diags[i].Excerpt = r.excerpt(d.Pos)
+		for j, n := range d.Notes {
+			d.Notes[j].Excerpt = r.excerpt(n.Pos)
+		}
+	}
+	sort.SliceStable(r.diags, func(i, j int) bool {
+		return r.diags[i].Pos.anchor().before(r.diags[j].Pos.anchor())
+	})
+	return r.diags
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func assembleString(src string) (*Image, error) {
+	return Assemble(*bufio.NewReader(strings.NewReader(src)), Options{Filename: "t.sm"})
+}
+
+func diagpositions(t *testing.T, err error) []string {
+	t.Helper()
+
+	var diags Diagnostics
+	if !errors.As(err, &diags) {
+		t.Fatalf("expected Diagnostics, got %T: %v", err, err)
+	}
+	res := make([]string, 0, len(diags))
+	for _, d := range diags {
+		res = append(res, d.Pos.String())
+	}
+	return res
+}
+
+func TestAssemble_diagnostics(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "unknown instruction",
+			src:  "push 1\n  frob\n",
+			want: []string{"t.sm:2:3"},
+		},
+		{
+			name: "integer out of range",
+			src:  "push 70000",
+			want: []string{"t.sm:1:6"},
+		},
+		{
+			name: "undefined label",
+			src:  "push &nowhere jmp",
+			want: []string{"t.sm:1:6"},
+		},
+		{
+			name: "duplicate label",
+			src:  "a:\nnop\na:",
+			want: []string{"t.sm:3:1"},
+		},
+		{
+			name: "illegal character",
+			src:  "push 1\npush 12a3 add",
+			want: []string{"t.sm:2:8"},
+		},
+		{
+			name: "bad literals",
+			src:  "push -$\npush 0o8\npush ''\npush 'ab'\npush 'a",
+			want: []string{"t.sm:1:7", "t.sm:2:8", "t.sm:3:6", "t.sm:4:6", "t.sm:5:6"},
+		},
+		{
+			name: "unterminated comment",
+			src:  "nop\n/* comment",
+			want: []string{"t.sm:2:1"},
+		},
+		{
+			name: "all errors are reported in source order",
+			src:  "push &x\nfrob\npush 99999\nbar",
+			want: []string{"t.sm:1:6", "t.sm:2:1", "t.sm:3:6", "t.sm:4:1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := assembleString(tt.src)
+			if got := diagpositions(t, err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diagnostic positions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssemble_noDiagnostics(t *testing.T) {
+	img, err := assembleString("a: push &a // comment\njmp")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []uint64{PUSH, 0, JMP}; !reflect.DeepEqual(img.Code, want) {
+		t.Errorf("code = %v, want %v", img.Code, want)
+	}
+}
+
+func TestDiagnostic_Error(t *testing.T) {
+	_, err := assembleString("nop\n\tpush frob\n")
+
+	want := "t.sm:2:7: error: unknown instruction 'frob'\n" +
+		"\tpush frob\n" +
+		"\t     ^"
+	if err == nil || err.Error() != want {
+		t.Errorf("error =\n%v\nwant\n%v", err, want)
+	}
+}
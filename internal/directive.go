@@ -0,0 +1,274 @@
+package internal
+
+import (
+	"strconv"
+	"strings"
+)
+
+// maxZero limits .zero, so a typo does not make
+// the assembler allocate gigabytes of words.
+const maxZero = 1 << 20
+
+// compiledirective handles assembler directives:
+//
+//	.text            switch to code section (default)
+//	.data            switch to data section
+//	.word v1 v2 ...  emit integers or label addresses
+//	.string "text"   emit characters followed by 0
+//	.zero n          emit n zero words
+//	.equ NAME value  define a constant
+//	.global l1 ...   export labels from an object
+//	.extern l1 ...   labels defined in another object
+//	.entry label     start execution at the label
+//	.width n         word width, before any words
+func (c *compiler) compiledirective(lx lexem) {
+	switch strings.ToLower(lx.val) {
+	case ".text":
+		c.section = SectionCode
+	case ".data":
+		c.section = SectionData
+	case ".word":
+		c.compilewords(lx)
+	case ".string":
+		c.compilestring(lx)
+	case ".zero":
+		c.compilezero(lx)
+	case ".equ":
+		c.compileequ(lx)
+	case ".global":
+		c.compilenames(lx, c.globals)
+	case ".extern":
+		c.compilenames(lx, c.externs)
+	case ".entry":
+		c.compileentry(lx)
+	case ".width":
+		c.compilewidth(lx)
+	default:
+		c.rep.errorf(lx.pos, "unknown directive '%s'", lx.val)
+	}
+}
+
+// operand returns the next lexem after a directive skipping
+// comments, or reports an error if it is not of the given type.
+func (c *compiler) operand(lx lexem, typ int, what string) (lexem, bool) {
+	for c.hasnext() {
+		op := c.next()
+		if op.typ == comment {
+			continue
+		}
+		if op.typ != typ {
+			c.unread(op)
+			break
+		}
+		return op, true
+	}
+	c.rep.errorf(lx.pos, "%s expects %s", lx.val, what)
+	return lexem{}, false
+}
+
+func (c *compiler) compilewords(lx lexem) {
+	n := 0
+	for c.hasnext() {
+		op := c.next()
+		if op.typ == comment {
+			continue
+		}
+		c.cur = op.pos
+
+		if op.typ == integer {
+			val, err := c.compileint(op.val)
+			if err != nil {
+				c.rep.errorf(op.pos, "%v", err)
+			}
+			c.emit(val)
+		} else if op.typ == labelreference {
+			c.emit(c.compilelabelref(op))
+		} else if isexproperand(op) {
+			c.emit(c.compileexpr(op))
+		} else {
+			c.unread(op)
+			break
+		}
+		n++
+	}
+
+	if n == 0 {
+		c.rep.errorf(lx.pos, "%s expects at least one integer or label reference", lx.val)
+	}
+}
+
+func (c *compiler) compilestring(lx lexem) {
+	op, ok := c.operand(lx, strlit, "a string")
+	if !ok {
+		return
+	}
+
+	c.cur = op.pos
+	str, err := strconv.Unquote(op.val)
+	if err != nil {
+		c.rep.errorf(op.pos, "malformed string literal %s", op.val)
+		return
+	}
+
+	for _, r := range str {
+		if uint64(r) > wordmask(c.wordwidth()) {
+			c.rep.errorf(op.pos, "character '%c' does not fit into %d bit word", r, c.wordwidth())
+			return
+		}
+		c.emit(uint64(r))
+	}
+	c.emit(0)
+}
+
+// compileentry only remembers the label, it is
+// resolved after the whole program is compiled.
+func (c *compiler) compileentry(lx lexem) {
+	op, ok := c.operand(lx, instruction, "a label name")
+	if !ok {
+		return
+	}
+	if c.object {
+		c.rep.errorf(lx.pos, "entry label is set when linking, not in an object")
+		return
+	}
+	if c.entry != "" && c.entry != op.val {
+		c.rep.errorf(op.pos, "entry label is already set to '%s'", c.entry)
+		return
+	}
+	c.entry = op.val
+}
+
+// compilewidth overrides the word width given in options,
+// words already emitted were checked against the old one.
+func (c *compiler) compilewidth(lx lexem) {
+	op, ok := c.operand(lx, integer, "a word width")
+	if !ok {
+		return
+	}
+	width, err := strconv.Atoi(op.val)
+	if err != nil || !validwidth(width) {
+		c.rep.errorf(op.pos, "unsupported word width: %s", op.val)
+		return
+	}
+	if len(c.out[SectionCode]) > 0 || len(c.out[SectionData]) > 0 {
+		c.rep.errorf(lx.pos, "%s should come before any words", lx.val)
+		return
+	}
+	c.width = width
+}
+
+// compilezero evaluates its count right away, so it
+// can only use constants and labels defined before it.
+func (c *compiler) compilezero(lx lexem) {
+	op, ok := c.exproperand(lx, "a word count")
+	if !ok {
+		return
+	}
+	node, ok := c.parseoperand(op)
+	if !ok {
+		return
+	}
+
+	n, err := c.evalword(node)
+	if err == errConstFailed {
+		return
+	}
+	if err != nil {
+		c.rep.errorf(op.pos, "%v", err)
+		return
+	}
+	if c.object {
+		if _, ok, err := c.reloctarget(node); ok || err != nil {
+			c.rep.errorf(op.pos, "%s count can not depend on label addresses in an object", lx.val)
+			return
+		}
+	}
+	if n > maxZero {
+		c.rep.errorf(op.pos, "%s count %d is too large, at most %d words allowed", lx.val, n, maxZero)
+		return
+	}
+
+	c.cur = op.pos
+	for i := uint64(0); i < n; i++ {
+		c.emit(0)
+	}
+}
+
+// isexproperand tells if lexem can be evaluated as an expression,
+// names of instructions are not treated as constants.
+func isexproperand(lx lexem) bool {
+	switch lx.typ {
+	case integer, labelreference, expression:
+		return true
+	case instruction:
+		return !Sinst(strings.ToLower(lx.val))
+	}
+	return false
+}
+
+func (c *compiler) exproperand(lx lexem, what string) (lexem, bool) {
+	for c.hasnext() {
+		op := c.next()
+		if op.typ == comment {
+			continue
+		}
+		if !isexproperand(op) {
+			c.unread(op)
+			break
+		}
+		return op, true
+	}
+	c.rep.errorf(lx.pos, "%s expects %s", lx.val, what)
+	return lexem{}, false
+}
+
+func (c *compiler) compileequ(lx lexem) {
+	name, ok := c.operand(lx, instruction, "a constant name")
+	if !ok {
+		return
+	}
+	op, ok := c.exproperand(lx, "a value")
+	if !ok {
+		return
+	}
+
+	if Sinst(strings.ToLower(name.val)) {
+		c.rep.errorf(name.pos, "instruction '%s' can not be used as a constant name", name.val)
+		return
+	}
+	if prev, ok := c.consts[name.val]; ok {
+		c.rep.errorf(name.pos, "constant '%s' redefined, previous definition at %s", name.val, prev.pos)
+		return
+	}
+
+	node, ok := c.parseoperand(op)
+	if !ok {
+		return
+	}
+	c.consts[name.val] = &equ{node: node, pos: name.pos}
+	c.constq = append(c.constq, name.val)
+}
+
+// compilenames collects label names following .global or .extern,
+// they are only used when compiling an object.
+func (c *compiler) compilenames(lx lexem, names map[string]Pos) {
+	n := 0
+	for c.hasnext() {
+		op := c.next()
+		if op.typ == comment {
+			continue
+		}
+		if op.typ != instruction || Sinst(strings.ToLower(op.val)) {
+			c.unread(op)
+			break
+		}
+		if _, ok := names[op.val]; !ok {
+			names[op.val] = op.pos
+		}
+		n++
+	}
+
+	if n == 0 {
+		c.rep.errorf(lx.pos, "%s expects at least one label name", lx.val)
+	}
+}
@@ -0,0 +1,190 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const datasrc = `
+.data
+tbl:    .word 1 -1 0x10 &msg // comment
+msg:    .string "hi\n"
+buf:    .zero 3
+end:
+
+.text
+start:
+        push &msg
+        load
+        out
+        push &end
+        outnum
+        term
+`
+
+func TestAssemble_data(t *testing.T) {
+	img, err := assembleString(datasrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wcode := []uint64{PUSH, 4, LOAD, OUT, PUSH, 11, OUTNUM, TERM}
+	if !reflect.DeepEqual(img.Code, wcode) {
+		t.Errorf("code = %v, want %v", img.Code, wcode)
+	}
+
+	wdata := []uint64{1, 0xFFFF, 0x10, 4, 'h', 'i', '\n', 0, 0, 0, 0}
+	if !reflect.DeepEqual(img.Data, wdata) {
+		t.Errorf("data = %v, want %v", img.Data, wdata)
+	}
+
+	wsyms := []Symbol{
+		{Name: "start", Section: SectionCode, Addr: 0},
+		{Name: "tbl", Section: SectionData, Addr: 0},
+		{Name: "msg", Section: SectionData, Addr: 4},
+		{Name: "buf", Section: SectionData, Addr: 8},
+		{Name: "end", Section: SectionData, Addr: 11},
+	}
+	if !reflect.DeepEqual(img.Symbols, wsyms) {
+		t.Errorf("symbols = %v, want %v", img.Symbols, wsyms)
+	}
+}
+
+func TestAssemble_dataRun(t *testing.T) {
+	img, err := assembleString(datasrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpu, err := FromImage(DefaultConfig(), img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := bytes.Buffer{}
+	cpu.SetIO(strings.NewReader(""), &out)
+	if err := cpu.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := out.String(), "h11\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestAssemble_directiveDiagnostics(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "unknown directive",
+			src:  ".bss",
+			want: []string{"t.sm:1:1"},
+		},
+		{
+			name: "word without values",
+			src:  ".data\n.word\n.text",
+			want: []string{"t.sm:2:1"},
+		},
+		{
+			name: "string without literal",
+			src:  ".string 1",
+			want: []string{"t.sm:1:1"},
+		},
+		{
+			name: "zero with label defined after it",
+			src:  ".zero &a a:",
+			want: []string{"t.sm:1:7"},
+		},
+		{
+			name: "zero too large",
+			src:  ".zero 0xFFFFFFFF",
+			want: []string{"t.sm:1:7"},
+		},
+		{
+			name: "instruction in data section",
+			src:  ".data\n  add",
+			want: []string{"t.sm:2:3"},
+		},
+		{
+			name: "string outside of directive",
+			src:  "push \"a\"",
+			want: []string{"t.sm:1:6"},
+		},
+		{
+			name: "unterminated string",
+			src:  ".string \"abc",
+			want: []string{"t.sm:1:1", "t.sm:1:9"},
+		},
+		{
+			name: "width after words",
+			src:  "nop\n.width 64",
+			want: []string{"t.sm:2:1"},
+		},
+		{
+			name: "unsupported width",
+			src:  ".width 8",
+			want: []string{"t.sm:1:8"},
+		},
+		{
+			name: "second entry label",
+			src:  ".entry a\n.entry b\na: b: term",
+			want: []string{"t.sm:2:8"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Assemble(*bufio.NewReader(strings.NewReader(tt.src)), Options{Filename: "t.sm", WordWidth: 32})
+			if got := diagpositions(t, err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diagnostic positions = %v, want %v (%v)", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestAssemble_entryInData(t *testing.T) {
+	_, err := Assemble(*bufio.NewReader(strings.NewReader(".data x: .word 1")), Options{Entry: "x"})
+	if err == nil {
+		t.Errorf("expected error for entry label in data section")
+	}
+}
+
+func TestAssemble_entryWidthDirectives(t *testing.T) {
+	img, err := assembleString(".width 32\n.entry main\npush 0x10000 drop main: term")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.WordWidth != 32 || img.Entry != 3 {
+		t.Errorf("width = %d, entry = %d, want 32 and 3", img.WordWidth, img.Entry)
+	}
+
+	_, err = Assemble(*bufio.NewReader(strings.NewReader(".entry a a: b: term")), Options{Entry: "b"})
+	if err == nil {
+		t.Errorf("expected error for entry label different from .entry")
+	}
+}
+
+func TestDisassembleImage_data(t *testing.T) {
+	img, err := assembleString(datasrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := DisassembleImage(img)
+	got, err := assembleString(src)
+	if err != nil {
+		t.Fatalf("Assemble(DisassembleImage()) error = %v\n%s", err, src)
+	}
+	if !reflect.DeepEqual(got.Code, img.Code) || !reflect.DeepEqual(got.Data, img.Data) {
+		t.Errorf("Assemble(DisassembleImage()) = %v %v, want %v %v\n%s", got.Code, got.Data, img.Code, img.Data, src)
+	}
+	for _, want := range []string{"msg:", "end:"} {
+		if !strings.Contains(src, want) {
+			t.Errorf("DisassembleImage() should contain %q\n%s", want, src)
+		}
+	}
+}
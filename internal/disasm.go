@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// jumps that take their target address from the stack top
+var jumpops = map[uint64]bool{
+	JMP:  true,
+	JZ:   true,
+	JNZ:  true,
+	CALL: true,
+	JC:   true,
+	JO:   true,
+	JN:   true,
+}
+
+// conditional jumps that are usually preceded by SWAP,
+// see `push &label; swap; jz` idiom
+var condjumpops = map[uint64]bool{
+	JZ:  true,
+	JNZ: true,
+}
+
+type disassembler struct {
+	program  []uint64
+	operand  []bool
+	labels   map[uint64]string
+	symnames map[uint64]string
+}
+
+// Disassemble turns program words back into assembler source,
+// that compiles to the same program.
+func Disassemble(program []uint64) string {
+	return newdisassembler(program, nil).disassemble()
+}
+
+// DisassembleImage works like Disassemble, but prefers names
+// from the image symbol table for labels. Word width and entry
+// point are kept with .width and .entry, unless the entry point
+// is inside an instruction and can not be labelled.
+func DisassembleImage(img *Image) string {
+	names := make(map[uint64]string)
+	for _, sym := range img.Symbols {
+		if sym.Section == SectionCode {
+			names[sym.Addr] = sym.Name
+		}
+	}
+	d := newdisassembler(img.Code, names)
+
+	res := ""
+	if img.WordWidth != 0 && img.WordWidth != defaultWordWidth {
+		res += fmt.Sprintf(".width %d\n", img.WordWidth)
+	}
+	if img.Entry != 0 {
+		if d.labelable(img.Entry) {
+			d.labels[img.Entry] = d.labelname(img.Entry)
+			res += fmt.Sprintf(".entry %s\n", d.labels[img.Entry])
+		} else {
+			res += fmt.Sprintf("// entry point: %#04x\n", img.Entry)
+		}
+	}
+	return res + d.disassemble() + disassembledata(img)
+}
+
+// disassembledata prints data section as .word
+// directives with data symbols as labels.
+func disassembledata(img *Image) string {
+	if len(img.Data) == 0 {
+		return ""
+	}
+
+	names := make(map[uint64]string)
+	for _, sym := range img.Symbols {
+		if sym.Section == SectionData {
+			names[sym.Addr] = sym.Name
+		}
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString("\n.data\n")
+	for i, word := range img.Data {
+		if name, ok := names[uint64(i)]; ok {
+			fmt.Fprintf(&sb, "/* %04x */   %s:\n", i, name)
+		}
+		fmt.Fprintf(&sb, "/* %04x */     .word %d\n", i, word)
+	}
+	if name, ok := names[uint64(len(img.Data))]; ok {
+		fmt.Fprintf(&sb, "/* %04x */   %s:\n", len(img.Data), name)
+	}
+	return sb.String()
+}
+
+func newdisassembler(program []uint64, symnames map[uint64]string) *disassembler {
+	d := &disassembler{
+		program:  program,
+		operand:  make([]bool, len(program)),
+		labels:   make(map[uint64]string),
+		symnames: symnames,
+	}
+	d.markoperands()
+	d.findlabels()
+	return d
+}
+
+// markoperands walks the program and marks words
+// that are immediates of PUSH instead of opcodes.
+func (d *disassembler) markoperands() {
+	for i := 0; i < len(d.program); i++ {
+		if d.program[i] == PUSH && i+1 < len(d.program) {
+			d.operand[i+1] = true
+			i++
+		}
+	}
+}
+
+func (d *disassembler) instr(i int) (uint64, bool) {
+	if i < 0 || i >= len(d.program) || d.operand[i] {
+		return 0, false
+	}
+	return d.program[i], true
+}
+
+// findlabels looks for pushed addresses that are used by a
+// jump right after the push. Labels take no space, so any
+// instruction or the end of the program can be named, but
+// not an immediate in the middle of PUSH.
+func (d *disassembler) findlabels() {
+	for i := 0; i+1 < len(d.program); i++ {
+		if d.operand[i] || d.program[i] != PUSH {
+			continue
+		}
+
+		target := d.program[i+1]
+		if !d.jumpsafter(i+2) || !d.labelable(target) {
+			continue
+		}
+
+		d.labels[target] = d.labelname(target)
+	}
+
+	// symbols are kept even if nothing jumps to them
+	for addr := range d.symnames {
+		if d.labelable(addr) {
+			d.labels[addr] = d.labelname(addr)
+		}
+	}
+}
+
+func (d *disassembler) labelable(addr uint64) bool {
+	if addr == uint64(len(d.program)) {
+		return true
+	}
+	_, ok := d.instr(int(addr))
+	return ok && addr < uint64(len(d.program))
+}
+
+func (d *disassembler) labelname(addr uint64) string {
+	if name, ok := d.symnames[addr]; ok {
+		return name
+	}
+	return fmt.Sprintf("l_%04x", addr)
+}
+
+func (d *disassembler) jumpsafter(at int) bool {
+	op, ok := d.instr(at)
+	if !ok {
+		return false
+	}
+	if jumpops[op] {
+		return true
+	}
+	if op == SWAP {
+		next, ok := d.instr(at + 1)
+		return ok && condjumpops[next]
+	}
+	return false
+}
+
+// word prints a single program word as an instruction,
+// a label reference or a number. Immediates are named only
+// when a jump uses them, data addresses and constants often
+// match code labels.
+func (d *disassembler) word(i int) string {
+	word := d.program[i]
+	if d.operand[i] {
+		if name, ok := d.labels[word]; ok && d.jumpsafter(i+1) {
+			return "&" + name
+		}
+		return fmt.Sprint(word)
+	}
+
+	name, err := itos(int(word))
+	if err != nil {
+		return fmt.Sprint(word)
+	}
+	return name
+}
+
+func (d *disassembler) disassemble() string {
+	sb := strings.Builder{}
+	for i := range d.program {
+		if name, ok := d.labels[uint64(i)]; ok {
+			fmt.Fprintf(&sb, "/* %04x */   %s:\n", i, name)
+		}
+		fmt.Fprintf(&sb, "/* %04x */     %s\n", i, d.word(i))
+	}
+	if name, ok := d.labels[uint64(len(d.program))]; ok {
+		fmt.Fprintf(&sb, "/* %04x */   %s:\n", len(d.program), name)
+	}
+	return sb.String()
+}
@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"bufio"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func compileString(t *testing.T, src string) []uint64 {
+	t.Helper()
+	prog, err := Compile(*bufio.NewReader(strings.NewReader(src)), false)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	return prog
+}
+
+func TestDisassemble_roundtrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []uint64
+	}{
+		{
+			name:    "plain instructions",
+			program: []uint64{ADD, IN, JMP, NOP, TERM},
+		},
+		{
+			name:    "push immediates",
+			program: []uint64{PUSH, 65535, PUSH, PUSH, OUTNUM},
+		},
+		{
+			name:    "unknown opcodes",
+			program: []uint64{0xFF, NOP, 1234},
+		},
+		{
+			name:    "push at program end",
+			program: []uint64{NOP, PUSH},
+		},
+		{
+			name:    "jump to a word that is not a nop",
+			program: []uint64{PUSH, 3, JMP, TERM},
+		},
+		{
+			name:    "jump past the program end",
+			program: []uint64{PUSH, 3, JMP},
+		},
+		{
+			name:    "jump into push immediate",
+			program: []uint64{PUSH, 1, JMP},
+		},
+		{
+			name:    "legacy nop labels",
+			program: []uint64{NOP, PUSH, 0, JMP},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := Disassemble(tt.program)
+			if got := compileString(t, src); !reflect.DeepEqual(got, tt.program) {
+				t.Errorf("Compile(Disassemble()) = %v, want %v\n%s", got, tt.program, src)
+			}
+		})
+	}
+}
+
+func TestDisassemble_sources(t *testing.T) {
+	for _, file := range []string{"../arr_sum.raw", "../convolution.raw"} {
+		t.Run(file, func(t *testing.T) {
+			raw, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			program := compileString(t, string(raw))
+			src := Disassemble(program)
+			if got := compileString(t, src); !reflect.DeepEqual(got, program) {
+				t.Errorf("Compile(Disassemble()) = %v, want %v", got, program)
+			}
+		})
+	}
+}
+
+func TestDisassemble_labels(t *testing.T) {
+	program := compileString(t, "loop: push &loop jmp push &end swap jz end: term")
+	src := Disassemble(program)
+
+	for _, want := range []string{"l_0000:", "&l_0000", "l_0007:", "&l_0007"} {
+		if !strings.Contains(src, want) {
+			t.Errorf("Disassemble() = %q, should contain %q", src, want)
+		}
+	}
+}
+
+func TestDisassembleImage_roundtrip(t *testing.T) {
+	tests := []struct {
+		name string
+		img  *Image
+	}{
+		{
+			name: "wide words and entry symbol",
+			img: &Image{
+				WordWidth: 32,
+				Entry:     3,
+				Code:      []uint64{PUSH, 0x12345678, DROP, PUSH, 0, LOAD, OUTNUM, TERM},
+				Data:      []uint64{0xFFFFFFFF},
+				Symbols:   []Symbol{{Name: "main", Section: SectionCode, Addr: 3}},
+			},
+		},
+		{
+			name: "entry without symbol",
+			img: &Image{
+				WordWidth: 64,
+				Entry:     2,
+				Code:      []uint64{NOP, NOP, PUSH, 1 << 63, OUTNUM, TERM},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := DisassembleImage(tt.img)
+			got, err := assembleString(src)
+			if err != nil {
+				t.Fatalf("Assemble(DisassembleImage()) error = %v\n%s", err, src)
+			}
+			if got.WordWidth != tt.img.WordWidth || got.Entry != tt.img.Entry ||
+				!reflect.DeepEqual(got.Code, tt.img.Code) || len(got.Data) != len(tt.img.Data) {
+				t.Errorf("Assemble(DisassembleImage()) = %+v, want %+v\n%s", got, tt.img, src)
+			}
+			for i := range tt.img.Data {
+				if got.Data[i] != tt.img.Data[i] {
+					t.Errorf("data = %v, want %v\n%s", got.Data, tt.img.Data, src)
+				}
+			}
+		})
+	}
+}
+
+func TestDisassembleImage_operands(t *testing.T) {
+	img, err := assembleString(`
+.equ N 2
+start:  push 0
+        load
+        push &end-&start
+        push N
+        push &start
+        swap
+        jz
+        push &end
+        jmp
+end:    term
+.data
+x:      .word 5`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := DisassembleImage(img)
+
+	// data address, label difference and constant
+	// equal to code addresses stay numbers
+	for _, want := range []string{
+		"/* 0001 */     0\n",
+		"/* 0004 */     14\n",
+		"/* 0006 */     2\n",
+		"/* 0008 */     &start\n",
+		"/* 000c */     &end\n",
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("DisassembleImage() =\n%s\nshould contain %q", src, want)
+		}
+	}
+
+	again, err := assembleString(src)
+	if err != nil {
+		t.Fatalf("assembling disassembled image: %v\n%s", err, src)
+	}
+	if !reflect.DeepEqual(again.Code, img.Code) || !reflect.DeepEqual(again.Data, img.Data) {
+		t.Errorf("assembled %v %v, want %v %v\n%s", again.Code, again.Data, img.Code, img.Data, src)
+	}
+}
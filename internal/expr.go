@@ -0,0 +1,411 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// expression node kinds
+const (
+	exprNum = iota + 1
+	exprLabel
+	exprConst
+	exprAdd
+	exprSub
+	exprMul
+	exprNeg
+)
+
+// exprnode is a parsed operand expression, e.g. `&table+3`,
+// `LEN*2` or `&end-&start`. Names without & are constants.
+type exprnode struct {
+	kind int
+	val  uint64
+	name string
+	l, r *exprnode
+}
+
+// exprerror points at the offending character of an expression.
+type exprerror struct {
+	at  int
+	msg string
+}
+
+func (e *exprerror) Error() string {
+	return e.msg
+}
+
+type exprparser struct {
+	src []rune
+	ptr int
+}
+
+// parseexpr parses
+//
+//	expr    = term { ("+" | "-") term }
+//	term    = unary { "*" unary }
+//	unary   = "-" unary | primary
+//	primary = number | char | "&" name | name | "(" expr ")"
+func parseexpr(src string) (*exprnode, error) {
+	p := &exprparser{src: []rune(src)}
+	node, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.ptr != len(p.src) {
+		return nil, p.errorf("unexpected '%c' in expression", p.src[p.ptr])
+	}
+	return node, nil
+}
+
+func (p *exprparser) errorf(format string, args ...interface{}) error {
+	return &exprerror{at: p.ptr, msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *exprparser) peek() rune {
+	if p.ptr >= len(p.src) {
+		return 0
+	}
+	return p.src[p.ptr]
+}
+
+func (p *exprparser) expr() (*exprnode, error) {
+	l, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == '+' || p.peek() == '-' {
+		kind := exprAdd
+		if p.peek() == '-' {
+			kind = exprSub
+		}
+		p.ptr++
+		r, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		l = &exprnode{kind: kind, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprparser) term() (*exprnode, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == '*' {
+		p.ptr++
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = &exprnode{kind: exprMul, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprparser) unary() (*exprnode, error) {
+	if p.peek() == '-' {
+		p.ptr++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprnode{kind: exprNeg, l: operand}, nil
+	}
+	return p.primary()
+}
+
+func (p *exprparser) primary() (*exprnode, error) {
+	next := p.peek()
+	switch {
+	case next == '(':
+		p.ptr++
+		node, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, p.errorf("expected ')' in expression")
+		}
+		p.ptr++
+		return node, nil
+
+	case next == '&':
+		p.ptr++
+		name := p.name()
+		if name == "" {
+			return nil, p.errorf("expected label name after '&'")
+		}
+		return &exprnode{kind: exprLabel, name: name}, nil
+
+	case digit(next):
+		at := p.ptr
+		return p.literal(at, p.name())
+
+	case next == '\'':
+		at := p.ptr
+		word, err := p.char()
+		if err != nil {
+			return nil, err
+		}
+		return p.literal(at, word)
+
+	case labstch(next):
+		return &exprnode{kind: exprConst, name: p.name()}, nil
+
+	case next == 0:
+		return nil, p.errorf("unexpected end of expression")
+	}
+	return nil, p.errorf("unexpected '%c' in expression", next)
+}
+
+// literal is checked against the widest word,
+// the result is fitted to the real width later.
+func (p *exprparser) literal(at int, word string) (*exprnode, error) {
+	val, err := parseint(word, 64)
+	if err != nil {
+		return nil, &exprerror{at: at, msg: err.Error()}
+	}
+	return &exprnode{kind: exprNum, val: val}, nil
+}
+
+// char returns a quoted character with its quotes,
+// escapes are decoded by compilechar.
+func (p *exprparser) char() (string, error) {
+	start := p.ptr
+	p.ptr++
+	for p.ptr < len(p.src) && p.src[p.ptr] != '\'' {
+		if p.src[p.ptr] == '\\' {
+			p.ptr++
+		}
+		p.ptr++
+	}
+	if p.ptr >= len(p.src) {
+		p.ptr = start
+		return "", p.errorf("unterminated character literal in expression")
+	}
+	p.ptr++
+	return string(p.src[start:p.ptr]), nil
+}
+
+// name also accepts @ used by labels local to macros.
+func (p *exprparser) name() string {
+	start := p.ptr
+	for p.ptr < len(p.src) && (labch(p.src[p.ptr]) || p.src[p.ptr] == '@') {
+		p.ptr++
+	}
+	return string(p.src[start:p.ptr])
+}
+
+// errConstFailed is returned for expressions that use a constant
+// with an error in its definition, which is reported once.
+var errConstFailed = errors.New("constant definition has errors")
+
+// equ is a constant defined by .equ, its value is evaluated
+// lazily, so constants may refer to labels defined later.
+type equ struct {
+	node   *exprnode
+	pos    Pos
+	val    *big.Int
+	busy   bool
+	failed bool
+}
+
+func (c *compiler) eval(node *exprnode) (*big.Int, error) {
+	switch node.kind {
+	case exprNum:
+		return new(big.Int).SetUint64(node.val), nil
+
+	case exprLabel:
+		addr, ok := c.labels[node.name]
+		if !ok && c.object && c.isextern(node.name) {
+			// imported symbols are added by the linker
+			return new(big.Int), nil
+		}
+		if !ok {
+			return nil, fmt.Errorf("undefined label '%s'", node.name)
+		}
+		return new(big.Int).SetUint64(addr), nil
+
+	case exprConst:
+		return c.constant(node.name)
+
+	case exprNeg:
+		v, err := c.eval(node.l)
+		if err != nil {
+			return nil, err
+		}
+		return v.Neg(v), nil
+	}
+
+	l, err := c.eval(node.l)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.eval(node.r)
+	if err != nil {
+		return nil, err
+	}
+	switch node.kind {
+	case exprAdd:
+		return l.Add(l, r), nil
+	case exprSub:
+		return l.Sub(l, r), nil
+	case exprMul:
+		return l.Mul(l, r), nil
+	}
+	panic(fmt.Errorf("unknown expression node: %d", node.kind))
+}
+
+func (c *compiler) constant(name string) (*big.Int, error) {
+	e, ok := c.consts[name]
+	if !ok {
+		return nil, fmt.Errorf("undefined constant '%s'", name)
+	}
+	if e.failed {
+		return nil, errConstFailed
+	}
+	if e.val != nil {
+		return new(big.Int).Set(e.val), nil
+	}
+	if e.busy {
+		return nil, fmt.Errorf("constant '%s' is defined in terms of itself", name)
+	}
+
+	e.busy = true
+	v, err := c.eval(e.node)
+	e.busy = false
+	if err != nil {
+		return nil, err
+	}
+	e.val = v
+	return new(big.Int).Set(v), nil
+}
+
+// toword checks that value fits into the word width as a signed
+// or unsigned number and converts it to two's complement.
+func toword(v *big.Int, width int) (uint64, error) {
+	min := new(big.Int).Lsh(big.NewInt(-1), uint(width-1))
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(width)), big.NewInt(1))
+	if v.Cmp(min) < 0 || v.Cmp(max) > 0 {
+		return 0, fmt.Errorf("value %s does not fit into %d bit word", v, width)
+	}
+
+	if v.Sign() >= 0 {
+		return v.Uint64(), nil
+	}
+	return uint64(v.Int64()) & wordmask(width), nil
+}
+
+// reloctarget is what an address in an object is relative to:
+// start of a section or an imported symbol.
+type reloctarget struct {
+	section int
+	symbol  string
+}
+
+// relocterms tells how many times every section start and
+// imported symbol is added up in the expression. Addresses of
+// labels in an object are offsets from the start of their section.
+func (c *compiler) relocterms(node *exprnode) (map[reloctarget]int64, error) {
+	switch node.kind {
+	case exprNum:
+		return map[reloctarget]int64{}, nil
+
+	case exprLabel:
+		if _, ok := c.labels[node.name]; !ok {
+			return map[reloctarget]int64{{symbol: node.name}: 1}, nil
+		}
+		return map[reloctarget]int64{{section: c.labelsec[node.name]}: 1}, nil
+
+	case exprConst:
+		e, ok := c.consts[node.name]
+		if !ok {
+			return nil, fmt.Errorf("undefined constant '%s'", node.name)
+		}
+		return c.relocterms(e.node)
+
+	case exprNeg:
+		terms, err := c.relocterms(node.l)
+		if err != nil {
+			return nil, err
+		}
+		for t := range terms {
+			terms[t] = -terms[t]
+		}
+		return terms, nil
+	}
+
+	l, err := c.relocterms(node.l)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.relocterms(node.r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node.kind {
+	case exprAdd, exprSub:
+		sign := int64(1)
+		if node.kind == exprSub {
+			sign = -1
+		}
+		for t, n := range r {
+			l[t] += sign * n
+			if l[t] == 0 {
+				delete(l, t)
+			}
+		}
+		return l, nil
+
+	case exprMul:
+		if len(l) != 0 && len(r) != 0 {
+			return nil, fmt.Errorf("product of addresses can not be relocated")
+		}
+		terms, factor := l, node.r
+		if len(r) != 0 {
+			terms, factor = r, node.l
+		}
+		if len(terms) == 0 {
+			return terms, nil
+		}
+		k, err := c.eval(factor)
+		if err != nil {
+			return nil, err
+		}
+		if !k.IsInt64() {
+			return nil, fmt.Errorf("value %s does not fit into 64 bit word", k)
+		}
+		for t := range terms {
+			terms[t] *= k.Int64()
+			if terms[t] == 0 {
+				delete(terms, t)
+			}
+		}
+		return terms, nil
+	}
+	panic(fmt.Errorf("unknown expression node: %d", node.kind))
+}
+
+// reloctarget returns what the value of an expression in an object
+// is relative to, ok is false if the value is an absolute number.
+func (c *compiler) reloctarget(node *exprnode) (reloctarget, bool, error) {
+	terms, err := c.relocterms(node)
+	if err != nil {
+		return reloctarget{}, false, err
+	}
+	if len(terms) == 0 {
+		return reloctarget{}, false, nil
+	}
+
+	for t, n := range terms {
+		if len(terms) == 1 && n == 1 {
+			return t, true, nil
+		}
+	}
+	return reloctarget{}, false, fmt.Errorf("expression can not be relocated, it should be a single address plus a constant")
+}
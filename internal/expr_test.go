@@ -0,0 +1,196 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseexpr(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    *exprnode
+		wantAt  int
+		wantErr bool
+	}{
+		{
+			name: "number",
+			src:  "0x10",
+			want: &exprnode{kind: exprNum, val: 16},
+		},
+		{
+			name: "label plus offset",
+			src:  "&table+3",
+			want: &exprnode{
+				kind: exprAdd,
+				l:    &exprnode{kind: exprLabel, name: "table"},
+				r:    &exprnode{kind: exprNum, val: 3},
+			},
+		},
+		{
+			name: "multiplication binds tighter",
+			src:  "1+LEN*2",
+			want: &exprnode{
+				kind: exprAdd,
+				l:    &exprnode{kind: exprNum, val: 1},
+				r: &exprnode{
+					kind: exprMul,
+					l:    &exprnode{kind: exprConst, name: "LEN"},
+					r:    &exprnode{kind: exprNum, val: 2},
+				},
+			},
+		},
+		{
+			name: "parentheses and negation",
+			src:  "-(1-2)",
+			want: &exprnode{
+				kind: exprNeg,
+				l: &exprnode{
+					kind: exprSub,
+					l:    &exprnode{kind: exprNum, val: 1},
+					r:    &exprnode{kind: exprNum, val: 2},
+				},
+			},
+		},
+		{name: "missing operand", src: "1+", wantAt: 2, wantErr: true},
+		{name: "unbalanced parentheses", src: "(1+2", wantAt: 4, wantErr: true},
+		{name: "extra parenthesis", src: "1+2)", wantAt: 3, wantErr: true},
+		{name: "label without name", src: "&+1", wantAt: 1, wantErr: true},
+		{name: "malformed number", src: "2+0x", wantAt: 2, wantErr: true},
+		{
+			name: "character",
+			src:  "'A'+1",
+			want: &exprnode{
+				kind: exprAdd,
+				l:    &exprnode{kind: exprNum, val: 'A'},
+				r:    &exprnode{kind: exprNum, val: 1},
+			},
+		},
+		{name: "escaped quote", src: "'\\''", want: &exprnode{kind: exprNum, val: '\''}},
+		{name: "leading zero", src: "1+010", wantAt: 2, wantErr: true},
+		{name: "underscore in number", src: "1_000+1", wantAt: 0, wantErr: true},
+		{name: "unterminated character", src: "1+'A", wantAt: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseexpr(tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseexpr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				var perr *exprerror
+				if !errors.As(err, &perr) || perr.at != tt.wantAt {
+					t.Errorf("parseexpr() error = %v, want it at %d", err, tt.wantAt)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseexpr() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssemble_expressions(t *testing.T) {
+	src := `
+.equ LEN 4
+.equ SIZE LEN*2
+.equ SPAN &end-&start  // labels defined later
+.equ NL '\n'
+        push SIZE
+        push &tbl+3
+        push -(LEN+1)
+        push SPAN
+        push 'A'+1
+        push ('a'-'A')*2
+        push NL
+start:  nop nop
+end:
+.data
+tbl:    .zero SIZE
+        .word LEN &tbl+1 0x41+1
+`
+	img, err := assembleString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wcode := []uint64{PUSH, 8, PUSH, 3, PUSH, 0xFFFB, PUSH, 2, PUSH, 'B', PUSH, 64, PUSH, '\n', NOP, NOP}
+	if !reflect.DeepEqual(img.Code, wcode) {
+		t.Errorf("code = %v, want %v", img.Code, wcode)
+	}
+	wdata := []uint64{0, 0, 0, 0, 0, 0, 0, 0, 4, 1, 'B'}
+	if !reflect.DeepEqual(img.Data, wdata) {
+		t.Errorf("data = %v, want %v", img.Data, wdata)
+	}
+}
+
+func TestAssemble_expressionDiagnostics(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "undefined constant",
+			src:  "push LEN+1",
+			want: []string{"t.sm:1:6"},
+		},
+		{
+			name: "undefined label in expression",
+			src:  "push &x+1",
+			want: []string{"t.sm:1:6"},
+		},
+		{
+			name: "does not fit into word",
+			src:  "push 0x8000*2",
+			want: []string{"t.sm:1:6"},
+		},
+		{
+			name: "syntax error points inside expression",
+			src:  "push (1+2",
+			want: []string{"t.sm:1:10"},
+		},
+		{
+			name: "cyclic constants are reported once",
+			src:  ".equ A B+1\n.equ B A\npush A push B",
+			want: []string{"t.sm:1:6"},
+		},
+		{
+			name: "constant redefined",
+			src:  ".equ A 1\n.equ A 2",
+			want: []string{"t.sm:2:6"},
+		},
+		{
+			name: "instruction as constant name",
+			src:  ".equ add 1",
+			want: []string{"t.sm:1:6"},
+		},
+		{
+			name: "octal without prefix",
+			src:  "push 1+010",
+			want: []string{"t.sm:1:8"},
+		},
+		{
+			name: "character too large in expression",
+			src:  "push '😀'+1",
+			want: []string{"t.sm:1:6"},
+		},
+		{
+			name: "equ without value",
+			src:  ".equ A",
+			want: []string{"t.sm:1:1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Assemble(*bufio.NewReader(strings.NewReader(tt.src)), Options{Filename: "t.sm"})
+			if got := diagpositions(t, err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diagnostic positions = %v, want %v (%v)", got, tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrHalted is returned when the cpu is ticked after it
+// has stopped, either by TERM or by a fault.
+var ErrHalted = errors.New("cpu is halted")
+
+// ErrStepLimit is returned by RunContext when the program
+// did not terminate within the given number of steps.
+var ErrStepLimit = errors.New("step limit exceeded")
+
+type FaultKind int
+
+const (
+	FaultStackOverflow FaultKind = iota + 1
+	FaultStackUnderflow
+	FaultBadOpcode
+	FaultDataAddress
+	FaultFetch
+	FaultIO
+	FaultReturnOverflow
+	FaultReturnUnderflow
+	FaultDivideByZero
+)
+
+var faultnames = map[FaultKind]string{
+	FaultStackOverflow:   "stack overflow",
+	FaultStackUnderflow:  "stack underflow",
+	FaultBadOpcode:       "unknown command",
+	FaultDataAddress:     "data address out of range",
+	FaultFetch:           "fetch past program end",
+	FaultIO:              "i/o error",
+	FaultReturnOverflow:  "return stack overflow",
+	FaultReturnUnderflow: "return stack underflow",
+	FaultDivideByZero:    "division by zero",
+}
+
+func (k FaultKind) String() string {
+	name, ok := faultnames[k]
+	if !ok {
+		return fmt.Sprintf("fault %d", int(k))
+	}
+	return name
+}
+
+// Handlers return a bare FaultKind as an error, tick
+// turns it into a *Fault with the machine state attached.
+func (k FaultKind) Error() string {
+	return k.String()
+}
+
+// Fault describes the instruction that stopped the cpu,
+// Stack is the stack before that instruction started.
+type Fault struct {
+	Kind   FaultKind
+	IP     int
+	Opcode uint64
+	Stack  []uint64
+	Err    error
+}
+
+func (f *Fault) Error() string {
+	op, err := itos(int(f.Opcode))
+	if err != nil {
+		op = fmt.Sprintf("%#04x", f.Opcode)
+	}
+
+	msg := fmt.Sprintf("%s at %#04x (%s), stack %v", f.Kind, f.IP, op, f.Stack)
+	if f.Err != nil {
+		msg += fmt.Sprintf(": %v", f.Err)
+	}
+	return msg
+}
+
+func (f *Fault) Unwrap() error {
+	return f.Err
+}
@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// WriteFile creates the file and fills it with write through
+// a buffer, e.g. WriteFile(name, func(w io.Writer) error {
+// return WriteImage(w, img) }). The first error is returned.
+func WriteFile(name string, write func(io.Writer) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	if err := write(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
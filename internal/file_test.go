@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "out")
+
+	err := WriteFile(name, func(w io.Writer) error {
+		_, err := io.WriteString(w, "hello")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("file = %q, want %q", got, "hello")
+	}
+
+	failed := errors.New("failed")
+	err = WriteFile(name, func(w io.Writer) error { return failed })
+	if err != failed {
+		t.Errorf("WriteFile() error = %v, want %v", err, failed)
+	}
+}
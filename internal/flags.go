@@ -0,0 +1,93 @@
+package internal
+
+// Status register bits.
+const (
+	FlagZero uint64 = 1 << iota
+	FlagCarry
+	FlagOverflow
+	FlagNegative
+)
+
+var flagnames = []struct {
+	flag uint64
+	name byte
+}{
+	{FlagZero, 'Z'},
+	{FlagCarry, 'C'},
+	{FlagOverflow, 'O'},
+	{FlagNegative, 'N'},
+}
+
+// formatFlags returns a letter for every set flag and
+// a dash for every cleared one, e.g. "Z--N".
+func formatFlags(flags uint64) string {
+	res := make([]byte, 0, len(flagnames))
+	for _, f := range flagnames {
+		if flags&f.flag != 0 {
+			res = append(res, f.name)
+		} else {
+			res = append(res, '-')
+		}
+	}
+	return string(res)
+}
+
+// setflags updates the status register after an arithmetic
+// or logic instruction that produced res.
+func (c *cpu) setflags(res uint64, carry, overflow bool) {
+	c.flags = 0
+	if res == 0 {
+		c.flags |= FlagZero
+	}
+	if res&c.signbit() != 0 {
+		c.flags |= FlagNegative
+	}
+	if carry {
+		c.flags |= FlagCarry
+	}
+	if overflow {
+		c.flags |= FlagOverflow
+	}
+}
+
+// pushres pushes res onto the stack and sets flags for it,
+// flags are left as they were if the push faults
+func (c *cpu) pushres(res uint64, carry, overflow bool) error {
+	if err := c.push(res); err != nil {
+		return err
+	}
+	c.setflags(res, carry, overflow)
+	return nil
+}
+
+// pop a, if flag is set goto a
+func (c *cpu) jumpif(flag uint64) error {
+	a, err := c.pop()
+	if err != nil {
+		return err
+	}
+	if c.flags&flag != 0 {
+		c.ip = int(a)
+	}
+	return nil
+}
+
+// push status register
+func (c *cpu) ipushf() error {
+	return c.push(c.flags)
+}
+
+// pop a, if carry goto a
+func (c *cpu) ijc() error {
+	return c.jumpif(FlagCarry)
+}
+
+// pop a, if overflow goto a
+func (c *cpu) ijo() error {
+	return c.jumpif(FlagOverflow)
+}
+
+// pop a, if negative goto a
+func (c *cpu) ijn() error {
+	return c.jumpif(FlagNegative)
+}
@@ -0,0 +1,184 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCpu_flags(t *testing.T) {
+	tests := []struct {
+		name  string
+		op    func(c *cpu) error
+		stack []int
+		want  uint64
+	}{
+		{
+			name:  "add should set zero and carry on unsigned wrap",
+			op:    (*cpu).iadd,
+			stack: []int{0xFFFF, 1},
+			want:  FlagZero | FlagCarry,
+		},
+		{
+			name:  "add should set overflow and negative on signed wrap",
+			op:    (*cpu).iadd,
+			stack: []int{0x7FFF, 1},
+			want:  FlagOverflow | FlagNegative,
+		},
+		{
+			name:  "sub should set carry on borrow",
+			op:    (*cpu).isub,
+			stack: []int{1, 2},
+			want:  FlagCarry | FlagNegative,
+		},
+		{
+			name:  "sub should set overflow on signed wrap",
+			op:    (*cpu).isub,
+			stack: []int{0x8000, 1},
+			want:  FlagOverflow,
+		},
+		{
+			name:  "mul should set carry when product does not fit",
+			op:    (*cpu).imul,
+			stack: []int{0x100, 0x100},
+			want:  FlagZero | FlagCarry | FlagOverflow,
+		},
+		{
+			name:  "mul of negative numbers should not overflow",
+			op:    (*cpu).imul,
+			stack: []int{-2, 3},
+			want:  FlagCarry | FlagNegative,
+		},
+		{
+			name:  "compl should overflow on the most negative word",
+			op:    (*cpu).icomp,
+			stack: []int{0x8000},
+			want:  FlagCarry | FlagOverflow | FlagNegative,
+		},
+		{
+			name:  "and should clear carry and overflow",
+			op:    (*cpu).iand,
+			stack: []int{0xF0, 0x0F},
+			want:  FlagZero,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cpu{
+				sp:    len(tt.stack) - 1,
+				stack: stinit(tt.stack),
+				flags: FlagCarry | FlagOverflow,
+			}
+
+			if err := tt.op(&c); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c.flags != tt.want {
+				t.Errorf("flags = %s, want %s", formatFlags(c.flags), formatFlags(tt.want))
+			}
+		})
+	}
+}
+
+func TestCpu_Run_flagjumps(t *testing.T) {
+	tests := []struct {
+		name string
+		jump uint64
+		a, b uint64
+		want string
+	}{
+		{name: "jc should jump on carry", jump: JC, a: 0xFFFF, b: 1, want: "1\n"},
+		{name: "jc should not jump without carry", jump: JC, a: 1, b: 1, want: "0\n"},
+		{name: "jo should jump on overflow", jump: JO, a: 0x7FFF, b: 1, want: "1\n"},
+		{name: "jn should jump on negative", jump: JN, a: 0, b: 0xFFFF, want: "1\n"},
+		{name: "jn should not jump on positive", jump: JN, a: 1, b: 1, want: "0\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := []uint64{
+				/* 00 */ PUSH, tt.a,
+				/* 02 */ PUSH, tt.b,
+				/* 04 */ ADD,
+				/* 05 */ DROP,
+				/* 06 */ PUSH, 13,
+				/* 08 */ tt.jump,
+				/* 09 */ PUSH, 0,
+				/* 11 */ OUTNUM,
+				/* 12 */ TERM,
+				/* 13 */ PUSH, 1,
+				/* 15 */ OUTNUM,
+				/* 16 */ TERM,
+			}
+
+			out := bytes.Buffer{}
+			c := WithMemProg(program, nil)
+			c.SetIO(strings.NewReader(""), &out)
+
+			if err := c.Run(); err != nil {
+				t.Fatalf("cpu.Run() error = %v", err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCpu_pushresFault(t *testing.T) {
+	c := cpu{
+		sp:    StackLimit - 1,
+		stack: stinit(make([]int, StackLimit)),
+		flags: FlagCarry,
+	}
+	if err := c.pushres(0, false, false); err != FaultStackOverflow {
+		t.Fatalf("pushres() error = %v, want %v", err, FaultStackOverflow)
+	}
+	if c.flags != FlagCarry {
+		t.Errorf("flags after a failed push = %s, want %s", formatFlags(c.flags), formatFlags(FlagCarry))
+	}
+}
+
+func TestCpu_signedMode(t *testing.T) {
+	tests := []struct {
+		name  string
+		op    uint64
+		b, a  int
+		want  string
+		flags uint64
+	}{
+		{name: "div truncates toward zero", op: DIV, b: -7, a: 2, want: "-3\n", flags: FlagNegative},
+		{name: "div of negative numbers", op: DIV, b: -8, a: -2, want: "4\n"},
+		{name: "div of the most negative word by -1 overflows", op: DIV, b: -0x8000, a: -1, want: "-32768\n", flags: FlagOverflow | FlagNegative},
+		{name: "mod takes the sign of the dividend", op: MOD, b: -7, a: 2, want: "-1\n", flags: FlagNegative},
+		{name: "mod of the most negative word by -1", op: MOD, b: -0x8000, a: -1, want: "0\n", flags: FlagZero},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Signed = true
+			program := []uint64{PUSH, uint64(tt.b) & 0xFFFF, PUSH, uint64(tt.a) & 0xFFFF, tt.op, OUTNUM, TERM}
+			c, err := WithConfig(cfg, program, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := bytes.Buffer{}
+			c.SetIO(strings.NewReader(""), &out)
+
+			if err := c.Run(); err != nil {
+				t.Fatalf("cpu.Run() error = %v", err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+			if c.flags != tt.flags {
+				t.Errorf("flags = %s, want %s", formatFlags(c.flags), formatFlags(tt.flags))
+			}
+		})
+	}
+}
+
+func Test_formatFlags(t *testing.T) {
+	if got := formatFlags(FlagZero | FlagNegative); got != "Z--N" {
+		t.Errorf("formatFlags() = %q, want %q", got, "Z--N")
+	}
+}
@@ -8,6 +8,18 @@ const (
 	fsmHexOrBinNumber
 	fsmHexNumber
 	fsmBinNumber
+	fsmOctNumber
+	fsmSign
+	fsmChar
+	fsmCharEscape
+	fsmCharClosed
+	fsmString
+	fsmStringEscape
+	fsmStringClosed
+	fsmDirective
+	fsmExpr
+	fsmExprChar
+	fsmExprCharEscape
 	fsmLabelref
 	fsmInstruction
 	fsmLabel
@@ -15,6 +27,7 @@ const (
 	fsmCommentML
 	fsmCommentMLClosing
 	fsmCommentSL
+	fsmSkip
 )
 
 type statehandle func(rune) int
@@ -28,6 +41,10 @@ type fsmlex struct {
 	ready     bool
 	exhausted bool
 	closed    bool
+	at        Pos
+	start     Pos
+	started   bool
+	rep       *reporter
 }
 
 func (f *fsmlex) init() {
@@ -42,6 +59,17 @@ func (f *fsmlex) inithmap() {
 		fsmHexOrBinNumber:   f.hbnumber,
 		fsmHexNumber:        f.hnumber,
 		fsmBinNumber:        f.bnumber,
+		fsmOctNumber:        f.onumber,
+		fsmSign:             f.sign,
+		fsmChar:             f.char,
+		fsmCharEscape:       f.charescape,
+		fsmCharClosed:       f.charclosed,
+		fsmString:           f.str,
+		fsmStringEscape:     f.strescape,
+		fsmDirective:        f.directive,
+		fsmExpr:             f.expr,
+		fsmExprChar:         f.exprchar,
+		fsmExprCharEscape:   f.exprcharescape,
 		fsmLabelref:         f.labelref,
 		fsmInstruction:      f.instr,
 		fsmLabel:            f.label,
@@ -49,6 +77,7 @@ func (f *fsmlex) inithmap() {
 		fsmCommentML:        f.commentml,
 		fsmCommentMLClosing: f.commentmlclosing,
 		fsmCommentSL:        f.commentsl,
+		fsmSkip:             f.skip,
 	}
 }
 
@@ -62,6 +91,31 @@ func (f *fsmlex) initial(next rune) int {
 		return fsmNumber
 	}
 
+	if next == '-' {
+		f.buf = append(f.buf, next)
+		return fsmSign
+	}
+
+	if next == '\'' {
+		f.buf = append(f.buf, next)
+		return fsmChar
+	}
+
+	if next == '"' {
+		f.buf = append(f.buf, next)
+		return fsmString
+	}
+
+	if next == '.' {
+		f.buf = append(f.buf, next)
+		return fsmDirective
+	}
+
+	if next == '(' {
+		f.buf = append(f.buf, next)
+		return fsmExpr
+	}
+
 	if next == '&' {
 		f.buf = append(f.buf, next)
 		return fsmLabelref
@@ -81,7 +135,7 @@ func (f *fsmlex) initial(next rune) int {
 		return fsmInitial
 	}
 
-	panic(fmt.Errorf("could not determine next state from '%v' at INITIAL", next))
+	return f.fail("illegal character: '%s'", string(next))
 }
 
 func (f *fsmlex) comment(next rune) int {
@@ -93,7 +147,7 @@ func (f *fsmlex) comment(next rune) int {
 		f.buf = append(f.buf, next)
 		return fsmCommentML
 	}
-	panic(fmt.Errorf("could not determine next state from '%v' at COMMENT", next))
+	return f.fail("illegal character after '/': '%s'", string(next))
 }
 
 func (f *fsmlex) commentml(next rune) int {
@@ -128,8 +182,105 @@ func (f *fsmlex) commentsl(next rune) int {
 	return fsmCommentSL
 }
 
+func (f *fsmlex) sign(next rune) int {
+	if next == '0' {
+		f.buf = append(f.buf, next)
+		return fsmHexOrBinNumber
+	}
+	if digit(next) {
+		f.buf = append(f.buf, next)
+		return fsmNumber
+	}
+	if labstch(next) || next == '&' || next == '(' {
+		f.buf = append(f.buf, next)
+		return fsmExpr
+	}
+	return f.fail("expected operand after '-', got '%s'", string(next))
+}
+
+// char reads a quoted character literal, escapes
+// are left for the compiler to interpret. Unlike a
+// string it may go on as an expression, e.g. 'A'+1.
+func (f *fsmlex) char(next rune) int {
+	if next == '\'' {
+		f.buf = append(f.buf, next)
+		return fsmCharClosed
+	}
+	return f.quoted(next, '\'', fsmChar, fsmCharEscape, fsmCharClosed)
+}
+
+func (f *fsmlex) charclosed(next rune) int {
+	if exprop(next) {
+		f.buf = append(f.buf, next)
+		return fsmExpr
+	}
+	f.yield()
+	return f.initial(next)
+}
+
+func (f *fsmlex) charescape(next rune) int {
+	return f.quotedescape(next, fsmChar)
+}
+
+func (f *fsmlex) str(next rune) int {
+	return f.quoted(next, '"', fsmString, fsmStringEscape, fsmStringClosed)
+}
+
+func (f *fsmlex) strescape(next rune) int {
+	return f.quotedescape(next, fsmString)
+}
+
+func (f *fsmlex) quoted(next rune, quote rune, open, escape, closed int) int {
+	if next == '\n' || next == '\r' {
+		f.fail("newline in %s literal", quotename[quote])
+		return fsmInitial
+	}
+	if next == quote {
+		f.buf = append(f.buf, next)
+		f.state = closed
+		f.yield()
+		return fsmInitial
+	}
+	f.buf = append(f.buf, next)
+	if next == '\\' {
+		return escape
+	}
+	return open
+}
+
+func (f *fsmlex) quotedescape(next rune, open int) int {
+	if next == '\n' || next == '\r' {
+		f.fail("newline in %s literal", quotename[f.buf[0]])
+		return fsmInitial
+	}
+	f.buf = append(f.buf, next)
+	return open
+}
+
+var quotename = map[rune]string{
+	'\'': "character",
+	'"':  "string",
+}
+
+func (f *fsmlex) directive(next rune) int {
+	if labch(next) {
+		f.buf = append(f.buf, next)
+		return fsmDirective
+	}
+	if next == '/' {
+		f.yield()
+		f.buf = append(f.buf, next)
+		return fsmComment
+	}
+	if whch(next) {
+		f.yield()
+		return fsmInitial
+	}
+	return f.fail("illegal character for directive: '%s'", string(next))
+}
+
 func (f *fsmlex) number(next rune) int {
-	if digit(next) || next == 'b' || next == 'x' {
+	if digit(next) {
 		f.buf = append(f.buf, next)
 		return fsmNumber
 	}
@@ -147,18 +298,26 @@ func (f *fsmlex) number(next rune) int {
 		f.yield()
 		return fsmInitial
 	}
-	panic(fmt.Errorf("illegal character inside number: '%s'", string(next)))
+	if exprop(next) {
+		f.buf = append(f.buf, next)
+		return fsmExpr
+	}
+	return f.fail("illegal character inside number: '%s'", string(next))
 }
 
 func (f *fsmlex) hbnumber(next rune) int {
-	if next == 'b' {
+	if next == 'b' || next == 'B' {
 		f.buf = append(f.buf, next)
 		return fsmBinNumber
 	}
-	if next == 'x' {
+	if next == 'x' || next == 'X' {
 		f.buf = append(f.buf, next)
 		return fsmHexNumber
 	}
+	if next == 'o' || next == 'O' {
+		f.buf = append(f.buf, next)
+		return fsmOctNumber
+	}
 	if next == '&' {
 		f.yield()
 		f.buf = append(f.buf, next)
@@ -174,7 +333,11 @@ func (f *fsmlex) hbnumber(next rune) int {
 		return fsmInitial
 	}
 
-	panic(fmt.Errorf("illegal number format: '%s'", string(next)))
+	if exprop(next) {
+		f.buf = append(f.buf, next)
+		return fsmExpr
+	}
+	return f.fail("illegal number format: '%s'", string(next))
 }
 
 func (f *fsmlex) hnumber(next rune) int {
@@ -196,7 +359,11 @@ func (f *fsmlex) hnumber(next rune) int {
 		f.yield()
 		return fsmInitial
 	}
-	panic(fmt.Errorf("illegal hex digit: '%s'", string(next)))
+	if exprop(next) {
+		f.buf = append(f.buf, next)
+		return fsmExpr
+	}
+	return f.fail("illegal hex digit: '%s'", string(next))
 }
 
 func (f *fsmlex) bnumber(next rune) int {
@@ -218,7 +385,37 @@ func (f *fsmlex) bnumber(next rune) int {
 		f.yield()
 		return fsmInitial
 	}
-	panic(fmt.Errorf("illegal binary digit: '%s'", string(next)))
+	if exprop(next) {
+		f.buf = append(f.buf, next)
+		return fsmExpr
+	}
+	return f.fail("illegal binary digit: '%s'", string(next))
+}
+
+func (f *fsmlex) onumber(next rune) int {
+	if octdig(next) {
+		f.buf = append(f.buf, next)
+		return fsmOctNumber
+	}
+	if next == '&' {
+		f.yield()
+		f.buf = append(f.buf, next)
+		return fsmLabelref
+	}
+	if next == '/' {
+		f.yield()
+		f.buf = append(f.buf, next)
+		return fsmComment
+	}
+	if whch(next) {
+		f.yield()
+		return fsmInitial
+	}
+	if exprop(next) {
+		f.buf = append(f.buf, next)
+		return fsmExpr
+	}
+	return f.fail("illegal octal digit: '%s'", string(next))
 }
 
 func (f *fsmlex) labelref(next rune) int {
@@ -240,7 +437,84 @@ func (f *fsmlex) labelref(next rune) int {
 		f.yield()
 		return fsmInitial
 	}
-	panic(fmt.Errorf("illegal character for labelref: '%v'", next))
+	if exprop(next) {
+		f.buf = append(f.buf, next)
+		return fsmExpr
+	}
+	return f.fail("illegal character for labelref: '%s'", string(next))
+}
+
+// fail reports an illegal character at the current position
+// and skips the rest of the malformed lexem.
+func (f *fsmlex) fail(format string, args ...interface{}) int {
+	if f.rep == nil {
+		f.rep = newreporter()
+	}
+	f.rep.errorf(f.at, format, args...)
+	f.buf = []rune{}
+	f.started = false
+	return fsmSkip
+}
+
+func (f *fsmlex) skip(next rune) int {
+	if next == '/' {
+		f.buf = append(f.buf, next)
+		return fsmComment
+	}
+	if whch(next) {
+		return fsmInitial
+	}
+	return fsmSkip
+}
+
+// expr reads an operand expression, it is parsed by the compiler.
+func (f *fsmlex) expr(next rune) int {
+	if labch(next) || next == '&' || exprop(next) {
+		f.buf = append(f.buf, next)
+		return fsmExpr
+	}
+	if next == '\'' {
+		f.buf = append(f.buf, next)
+		return fsmExprChar
+	}
+	if next == '/' {
+		f.yield()
+		f.buf = append(f.buf, next)
+		return fsmComment
+	}
+	if whch(next) {
+		f.yield()
+		return fsmInitial
+	}
+	return f.fail("illegal character in expression: '%s'", string(next))
+}
+
+// exprchar reads a character literal inside an expression,
+// the expression goes on after the closing quote.
+func (f *fsmlex) exprchar(next rune) int {
+	if next == '\n' || next == '\r' {
+		return f.fail("newline in character literal")
+	}
+	f.buf = append(f.buf, next)
+	if next == '\'' {
+		return fsmExpr
+	}
+	if next == '\\' {
+		return fsmExprCharEscape
+	}
+	return fsmExprChar
+}
+
+func (f *fsmlex) exprcharescape(next rune) int {
+	if next == '\n' || next == '\r' {
+		return f.fail("newline in character literal")
+	}
+	f.buf = append(f.buf, next)
+	return fsmExprChar
+}
+
+func exprop(r rune) bool {
+	return r == '+' || r == '-' || r == '*' || r == '(' || r == ')'
 }
 
 func whch(r rune) bool {
@@ -261,6 +535,10 @@ func bindig(r rune) bool {
 	return r == '0' || r == '1'
 }
 
+func octdig(r rune) bool {
+	return '0' <= r && r <= '7'
+}
+
 func lowch(r rune) bool {
 	return 'a' <= r && r <= 'z'
 }
@@ -282,6 +560,11 @@ var statetyp = map[int]int{
 	fsmHexOrBinNumber:   integer,
 	fsmHexNumber:        integer,
 	fsmBinNumber:        integer,
+	fsmOctNumber:        integer,
+	fsmCharClosed:       integer,
+	fsmStringClosed:     strlit,
+	fsmDirective:        directive,
+	fsmExpr:             expression,
 	fsmLabelref:         labelreference,
 	fsmInstruction:      instruction,
 	fsmLabel:            label,
@@ -294,15 +577,28 @@ func (f *fsmlex) yield() {
 
 	typ, ok := statetyp[f.state]
 	if !ok {
-		panic(fmt.Errorf("could not decide on token type: '%s'", lexem1))
+		f.at = f.start
+		switch f.state {
+		case fsmComment, fsmCommentML:
+			f.fail("unterminated comment: '%s'", lexem1)
+		case fsmChar, fsmCharEscape, fsmExprChar, fsmExprCharEscape:
+			f.fail("unterminated character literal: %s", lexem1)
+		case fsmString, fsmStringEscape:
+			f.fail("unterminated string literal: %s", lexem1)
+		default:
+			f.fail("could not decide on token type: '%s'", lexem1)
+		}
+		return
 	}
 
 	f.outbox = lexem{
 		val: string(f.buf),
 		typ: typ,
+		pos: f.start,
 	}
 
 	f.buf = []rune{}
+	f.started = false
 	f.ready = true
 }
 
@@ -331,7 +627,11 @@ func (f *fsmlex) instr(next rune) int {
 		f.buf = append(f.buf, next)
 		return fsmComment
 	}
-	panic(fmt.Errorf("illegal character for instruction or label: '%s'", string(next)))
+	if exprop(next) {
+		f.buf = append(f.buf, next)
+		return fsmExpr
+	}
+	return f.fail("illegal character for instruction or label: '%s'", string(next))
 }
 
 func (f *fsmlex) label(next rune) int {
@@ -339,13 +639,19 @@ func (f *fsmlex) label(next rune) int {
 		f.yield()
 		return fsmInitial
 	}
-	panic(fmt.Errorf("illegal character for label: '%v'", next))
+	return f.fail("illegal character for label: '%s'", string(next))
 }
 
 func newfsmlex(runeiter runeiterator) *fsmlex {
+	return newfsmlexwith(runeiter, newreporter())
+}
+
+// newfsmlexwith is newfsmlex reporting errors to rep.
+func newfsmlexwith(runeiter runeiterator, rep *reporter) *fsmlex {
 	ret := &fsmlex{
 		runeiter: runeiter,
 		state:    fsmInitial,
+		rep:      rep,
 	}
 	ret.init()
 	return ret
@@ -357,6 +663,7 @@ func (f *fsmlex) walk() {
 	}
 
 	for f.runeiter.hasnext() {
+		f.at = f.runeiter.pos()
 		next := f.runeiter.next()
 
 		h, ok := f.hmap[f.state]
@@ -366,6 +673,11 @@ func (f *fsmlex) walk() {
 
 		f.state = h(next)
 
+		if len(f.buf) != 0 && !f.started {
+			f.start = f.at
+			f.started = true
+		}
+
 		if f.ready {
 			return
 		}
@@ -375,8 +687,10 @@ func (f *fsmlex) walk() {
 	// unfinished lexem on future next() call.
 	if len(f.buf) != 0 {
 		f.yield()
-		f.exhausted = true
-		return
+		if f.ready {
+			f.exhausted = true
+			return
+		}
 	}
 
 	f.closed = true
@@ -20,6 +20,19 @@ func (s *striter) hasnext() bool {
 	return s.ptr < len(s.buf)
 }
 
+func (s *striter) pos() Pos {
+	p := Pos{Line: 1, Col: 1}
+	for _, r := range s.buf[:s.ptr] {
+		if r == '\n' {
+			p.Line++
+			p.Col = 1
+		} else {
+			p.Col++
+		}
+	}
+	return p
+}
+
 func Test_fsmlex_next(t *testing.T) {
 	tests := []struct {
 		name string
@@ -234,6 +247,7 @@ func Test_fsmlex_next(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.li.init()
 			got := tt.li.next()
+			got.pos = Pos{}
 
 			if tt.want != got {
 				t.Errorf("unexpected outbox value: '%v', expected : '%v'", tt.li.outbox, tt.want)
@@ -246,7 +260,9 @@ func lex(src string) []lexem {
 	res := make([]lexem, 0)
 	iter := newfsmlex(&striter{buf: []rune(src)})
 	for iter.hasnext() {
-		res = append(res, iter.next())
+		lx := iter.next()
+		lx.pos = Pos{}
+		res = append(res, lx)
 	}
 	return res
 }
@@ -363,6 +379,45 @@ func Test_lex(t *testing.T) {
 			},
 		},
 
+		{
+			name: "octal and signed numbers",
+			args: args{
+				src: "0o17 -1 -0x10\t0O7//c\n-0b1",
+			},
+			want: []lexem{
+				mkinteger("0o17"),
+				mkinteger("-1"),
+				mkinteger("-0x10"),
+				mkinteger("0O7"),
+				mkcomment("//c"),
+				mkinteger("-0b1"),
+			},
+		},
+		{
+			name: "character literals",
+			args: args{
+				src: "'A' ' ' '\\'' '\\\\'out '/'",
+			},
+			want: []lexem{
+				mkinteger("'A'"),
+				mkinteger("' '"),
+				mkinteger("'\\''"),
+				mkinteger("'\\\\'"),
+				mkinstr("out"),
+				mkinteger("'/'"),
+			},
+		},
+		{
+			name: "character literals in expressions",
+			args: args{
+				src: "'A'+1 (' '+'\\'')",
+			},
+			want: []lexem{
+				{val: "'A'+1", typ: expression},
+				{val: "(' '+'\\'')", typ: expression},
+			},
+		},
+
 		// labels
 		{
 			name: "labels no whitespace",
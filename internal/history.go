@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoHistory is returned when going back past
+// the oldest instruction kept in the history.
+var ErrNoHistory = errors.New("no more history")
+
+// memories an instruction may write to
+const (
+	memStack = iota
+	memRstack
+	memData
+)
+
+// memchange is the old value of an overwritten word.
+type memchange struct {
+	mem  int
+	addr int
+	old  uint64
+}
+
+// undoentry holds registers before an instruction
+// and every word the instruction overwrote.
+type undoentry struct {
+	ip, sp, rsp int
+	cnt, flags  uint64
+	running     bool
+	steps       int
+	changes     []memchange
+}
+
+// history is an undo log of executed instructions,
+// limit is how many of them are kept, 0 for all.
+type history struct {
+	entries []undoentry
+	limit   int
+}
+
+// EnableHistory makes the cpu record an undo log, so it can go
+// back with StepBack, RewindTo and BackToWrite. At most limit
+// instructions are kept (0 for no limit). Input consumed by IN
+// and output already written are not taken back.
+func (c *cpu) EnableHistory(limit int) {
+	c.history = &history{limit: limit}
+}
+
+// ClearHistory forgets recorded instructions, e.g. after the
+// machine state is changed by hand and can not be undone.
+func (c *cpu) ClearHistory() {
+	if c.history != nil {
+		c.history.entries = nil
+	}
+}
+
+// HistoryLen returns how many instructions can be undone.
+func (c *cpu) HistoryLen() int {
+	if c.history == nil {
+		return 0
+	}
+	return len(c.history.entries)
+}
+
+// record starts an undo entry for the instruction about to run.
+func (c *cpu) record() {
+	h := c.history
+	if h == nil {
+		return
+	}
+	if h.limit > 0 && len(h.entries) >= h.limit {
+		// append moves entries to a new array once
+		// the old one is used up, so this is cheap
+		h.entries = h.entries[1:]
+	}
+	h.entries = append(h.entries, undoentry{
+		ip:      c.ip,
+		sp:      c.sp,
+		rsp:     c.rsp,
+		cnt:     c.cnt,
+		flags:   c.flags,
+		running: c.running,
+		steps:   c.steps,
+	})
+}
+
+// remember saves the word about to be overwritten.
+func (c *cpu) remember(mem int, addr int, old uint64) {
+	if mem == memStack {
+		c.undo = append(c.undo, memchange{mem: mem, addr: addr, old: old})
+	}
+
+	h := c.history
+	if h == nil || len(h.entries) == 0 {
+		return
+	}
+	e := &h.entries[len(h.entries)-1]
+	e.changes = append(e.changes, memchange{mem: mem, addr: addr, old: old})
+}
+
+// StepBack undoes the last executed instruction,
+// including the one that faulted or terminated.
+func (c *cpu) StepBack() error {
+	h := c.history
+	if h == nil || len(h.entries) == 0 {
+		return ErrNoHistory
+	}
+
+	e := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	for i := len(e.changes) - 1; i >= 0; i-- {
+		ch := e.changes[i]
+		switch ch.mem {
+		case memStack:
+			c.stack[ch.addr] = ch.old
+		case memRstack:
+			c.rstack[ch.addr] = ch.old
+		case memData:
+			c.data[ch.addr] = ch.old
+		}
+	}
+	c.ip, c.sp, c.rsp = e.ip, e.sp, e.rsp
+	c.cnt, c.flags = e.cnt, e.flags
+	c.running = e.running
+	c.steps = e.steps
+	return nil
+}
+
+// RewindTo goes back until step instructions are executed.
+func (c *cpu) RewindTo(step int) error {
+	if step > c.steps {
+		return fmt.Errorf("can not rewind forward to step %d, at step %d", step, c.steps)
+	}
+	if oldest := c.oldeststep(); step < oldest {
+		return fmt.Errorf("%w: oldest step is %d", ErrNoHistory, oldest)
+	}
+	for c.steps > step || c.lastfaulted() {
+		if err := c.StepBack(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BackToWrite goes back to the last instruction that wrote
+// to data word addr and stops before it, so ip points at it.
+func (c *cpu) BackToWrite(addr int) error {
+	h := c.history
+	if h == nil {
+		return ErrNoHistory
+	}
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		for _, ch := range h.entries[i].changes {
+			if ch.mem != memData || ch.addr != addr {
+				continue
+			}
+			for len(h.entries) > i {
+				if err := c.StepBack(); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no write to data %#04x", ErrNoHistory, addr)
+}
+
+// oldeststep is the step the cpu was at before
+// the oldest instruction kept in the history.
+func (c *cpu) oldeststep() int {
+	if c.history == nil || len(c.history.entries) == 0 {
+		return c.steps
+	}
+	return c.history.entries[0].steps
+}
+
+// lastfaulted tells if the last entry is an instruction
+// that did not complete, it does not count as a step.
+func (c *cpu) lastfaulted() bool {
+	h := c.history
+	if h == nil || len(h.entries) == 0 {
+		return false
+	}
+	return h.entries[len(h.entries)-1].steps == c.steps && !c.running
+}
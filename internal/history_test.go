@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// machine is everything StepBack should restore.
+type machine struct {
+	stack, rstack, data []uint64
+	ip, sp, rsp, steps  int
+	cnt, flags          uint64
+	running             bool
+}
+
+func snapshot(c *cpu) machine {
+	return machine{
+		stack:   c.StackDump(),
+		rstack:  c.ReturnStackDump(),
+		data:    c.DataDump(),
+		ip:      c.ip,
+		sp:      c.sp,
+		rsp:     c.rsp,
+		steps:   c.steps,
+		cnt:     c.cnt,
+		flags:   c.flags,
+		running: c.running,
+	}
+}
+
+const historyprog = `
+        push 3
+        stc
+loop:   push &double
+        call
+        cdec
+        cts
+        push &loop
+        swap
+        jnz
+        push 0
+        drop
+        drop
+double: push &acc
+        load
+        dup
+        add
+        push 1
+        add
+        push &acc
+        stor
+        ret
+.data
+acc:    .word 0`
+
+func TestCpu_stepBackRestoresEveryState(t *testing.T) {
+	c, _, _ := hookedcpu(t, historyprog, []uint64{0})
+	c.EnableHistory(0)
+
+	states := []machine{snapshot(c)}
+	for {
+		err := c.tick()
+		states = append(states, snapshot(c))
+		if err != nil {
+			var fault *Fault
+			if !errors.As(err, &fault) || fault.Kind != FaultStackUnderflow {
+				t.Fatalf("expected the program to underflow, got %v", err)
+			}
+			break
+		}
+	}
+
+	for i := len(states) - 2; i >= 0; i-- {
+		if err := c.StepBack(); err != nil {
+			t.Fatalf("StepBack() error = %v", err)
+		}
+		if got := snapshot(c); !reflect.DeepEqual(got, states[i]) {
+			t.Fatalf("state %d after StepBack =\n%+v\nwant\n%+v", i, got, states[i])
+		}
+	}
+	if err := c.StepBack(); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("StepBack() at the start error = %v, want %v", err, ErrNoHistory)
+	}
+
+	// the program runs the same way again
+	if err := c.Run(); err == nil {
+		t.Errorf("expected the program to underflow again")
+	}
+	if got := snapshot(c); !reflect.DeepEqual(got, states[len(states)-1]) {
+		t.Errorf("state after rerun =\n%+v\nwant\n%+v", got, states[len(states)-1])
+	}
+}
+
+func TestCpu_rewindTo(t *testing.T) {
+	c, _, _ := hookedcpu(t, historyprog, []uint64{0})
+	c.EnableHistory(0)
+
+	states := []machine{snapshot(c)}
+	for c.tick() == nil {
+		states = append(states, snapshot(c))
+	}
+
+	if err := c.RewindTo(len(states)); err == nil {
+		t.Errorf("RewindTo() forward should fail")
+	}
+	for _, step := range []int{len(states) - 1, 20, 7, 0} {
+		if err := c.RewindTo(step); err != nil {
+			t.Fatalf("RewindTo(%d) error = %v", step, err)
+		}
+		if got := snapshot(c); !reflect.DeepEqual(got, states[step]) {
+			t.Errorf("state after RewindTo(%d) =\n%+v\nwant\n%+v", step, got, states[step])
+		}
+	}
+}
+
+func TestCpu_backToWrite(t *testing.T) {
+	c, img, _ := hookedcpu(t, historyprog, []uint64{0})
+	c.EnableHistory(0)
+	_ = c.Run()
+
+	if err := c.BackToWrite(0); err != nil {
+		t.Fatal(err)
+	}
+	// stopped before the stor that turns 3 into 7
+	if img.Code[c.ip] != STOR || c.data[0] != 3 {
+		t.Errorf("stopped at %#04x with acc %d, want a stor with acc 3", c.ip, c.data[0])
+	}
+
+	if err := c.BackToWrite(0); err != nil {
+		t.Fatal(err)
+	}
+	if c.data[0] != 1 {
+		t.Errorf("acc before the second to last write = %d, want 1", c.data[0])
+	}
+
+	if err := c.BackToWrite(5); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("BackToWrite() of unwritten word error = %v, want %v", err, ErrNoHistory)
+	}
+}
+
+func TestCpu_historyLimit(t *testing.T) {
+	c, _, _ := hookedcpu(t, historyprog, []uint64{0})
+	c.EnableHistory(5)
+	_ = c.Run()
+
+	if c.HistoryLen() != 5 {
+		t.Fatalf("HistoryLen() = %d, want 5", c.HistoryLen())
+	}
+	steps := c.Steps()
+	if err := c.RewindTo(steps - 5); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("RewindTo() past the limit error = %v, want %v", err, ErrNoHistory)
+	}
+	if err := c.RewindTo(steps - 4); err != nil {
+		t.Errorf("RewindTo() within the limit error = %v", err)
+	}
+}
@@ -0,0 +1,302 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AnyAddress makes a breakpoint check its
+// condition after every instruction.
+const AnyAddress = -1
+
+// condition operands
+const (
+	CondTop = iota + 1
+	CondCounter
+	CondSteps
+)
+
+var condoperands = map[int]string{
+	CondTop:     "top",
+	CondCounter: "counter",
+	CondSteps:   "steps",
+}
+
+var condops = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// Condition compares stack top, counter register or number of
+// executed instructions with a value, e.g. `counter == 3`.
+// Condition on stack top is false if the stack is empty.
+type Condition struct {
+	Operand int
+	Op      string
+	Value   uint64
+}
+
+// ParseCondition parses `operand op value`, where value
+// is an unsigned number in any base strconv understands.
+func ParseCondition(fields []string) (Condition, error) {
+	if len(fields) != 3 {
+		return Condition{}, fmt.Errorf("condition should look like 'counter == 3'")
+	}
+
+	cond := Condition{}
+	for operand, name := range condoperands {
+		if name == fields[0] {
+			cond.Operand = operand
+		}
+	}
+	if cond.Operand == 0 {
+		return Condition{}, fmt.Errorf("unknown condition operand '%s', expected top, counter or steps", fields[0])
+	}
+	for _, op := range condops {
+		if op == fields[1] {
+			cond.Op = op
+		}
+	}
+	if cond.Op == "" {
+		return Condition{}, fmt.Errorf("unknown comparison '%s'", fields[1])
+	}
+
+	val, err := strconv.ParseUint(fields[2], 0, 64)
+	if err != nil {
+		return Condition{}, fmt.Errorf("malformed value '%s'", fields[2])
+	}
+	cond.Value = val
+	return cond, nil
+}
+
+func (cond Condition) String() string {
+	return fmt.Sprintf("%s %s %d", condoperands[cond.Operand], cond.Op, cond.Value)
+}
+
+func (cond Condition) holds(c *cpu) bool {
+	var x uint64
+	switch cond.Operand {
+	case CondTop:
+		if c.sp < 0 {
+			return false
+		}
+		x = c.stack[c.sp]
+	case CondCounter:
+		x = c.cnt
+	case CondSteps:
+		x = uint64(c.steps)
+	}
+
+	switch cond.Op {
+	case "==":
+		return x == cond.Value
+	case "!=":
+		return x != cond.Value
+	case "<":
+		return x < cond.Value
+	case "<=":
+		return x <= cond.Value
+	case ">":
+		return x > cond.Value
+	case ">=":
+		return x >= cond.Value
+	}
+	return false
+}
+
+// Breakpoint stops the cpu when the instruction pointer reaches
+// Addr and Cond, if any, holds. Breakpoints are checked after
+// every instruction, so the one at the entry point is not hit.
+type Breakpoint struct {
+	Addr int
+	Cond *Condition
+}
+
+func (bp Breakpoint) String() string {
+	res := fmt.Sprintf("%#04x", bp.Addr)
+	if bp.Addr == AnyAddress {
+		res = "*"
+	}
+	if bp.Cond != nil {
+		res += " if " + bp.Cond.String()
+	}
+	return res
+}
+
+// Watchpoint stops the cpu after an instruction
+// reads or writes the data word at Addr.
+type Watchpoint struct {
+	Addr  int
+	Read  bool
+	Write bool
+}
+
+// event kinds
+const (
+	EventBreak = iota + 1
+	EventRead
+	EventWrite
+)
+
+// Event is a breakpoint or a watchpoint that was hit. IP is the
+// instruction that caused it, for breakpoints the one about to
+// be executed. Old and New are the data word before and after
+// a write, Old is the value read for reads.
+type Event struct {
+	Kind int
+	IP   int
+	Addr int
+	Old  uint64
+	New  uint64
+	Cond *Condition
+}
+
+func (e Event) String() string {
+	switch e.Kind {
+	case EventRead:
+		return fmt.Sprintf("instruction at %#04x read %d from data %#04x", e.IP, e.Old, e.Addr)
+	case EventWrite:
+		return fmt.Sprintf("instruction at %#04x wrote %d to data %#04x, was %d", e.IP, e.New, e.Addr, e.Old)
+	}
+	if e.Cond != nil {
+		return fmt.Sprintf("breakpoint at %#04x, %s", e.IP, e.Cond)
+	}
+	return fmt.Sprintf("breakpoint at %#04x", e.IP)
+}
+
+// Break is returned by tick when breakpoints or watchpoints are hit,
+// unlike a fault it does not stop the cpu and running may go on.
+type Break struct {
+	Events []Event
+}
+
+func (b *Break) Error() string {
+	msgs := make([]string, 0, len(b.Events))
+	for _, e := range b.Events {
+		msgs = append(msgs, e.String())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// hooks keep breakpoints, watchpoints and event subscribers.
+type hooks struct {
+	breaks      map[int][]Breakpoint
+	watches     map[int]Watchpoint
+	subscribers []func(Event)
+	events      []Event
+}
+
+// SetBreakpoint adds a breakpoint, several
+// breakpoints may share the same address.
+func (c *cpu) SetBreakpoint(bp Breakpoint) {
+	if c.hooks.breaks == nil {
+		c.hooks.breaks = make(map[int][]Breakpoint)
+	}
+	c.hooks.breaks[bp.Addr] = append(c.hooks.breaks[bp.Addr], bp)
+}
+
+// ClearBreakpoints removes breakpoints at the address
+// and tells if there were any.
+func (c *cpu) ClearBreakpoints(addr int) bool {
+	_, ok := c.hooks.breaks[addr]
+	delete(c.hooks.breaks, addr)
+	return ok
+}
+
+// Breakpoints returns breakpoints ordered by address.
+func (c *cpu) Breakpoints() []Breakpoint {
+	res := make([]Breakpoint, 0)
+	for _, bps := range c.hooks.breaks {
+		res = append(res, bps...)
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Addr < res[j].Addr })
+	return res
+}
+
+// Watch adds a watchpoint, replacing the one at the same address.
+func (c *cpu) Watch(w Watchpoint) {
+	if c.hooks.watches == nil {
+		c.hooks.watches = make(map[int]Watchpoint)
+	}
+	c.hooks.watches[w.Addr] = w
+}
+
+// Unwatch removes the watchpoint and tells if there was one.
+func (c *cpu) Unwatch(addr int) bool {
+	_, ok := c.hooks.watches[addr]
+	delete(c.hooks.watches, addr)
+	return ok
+}
+
+// Watchpoints returns watchpoints ordered by address.
+func (c *cpu) Watchpoints() []Watchpoint {
+	res := make([]Watchpoint, 0, len(c.hooks.watches))
+	for _, w := range c.hooks.watches {
+		res = append(res, w)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Addr < res[j].Addr })
+	return res
+}
+
+// Subscribe calls fn for every event, before tick returns it.
+func (c *cpu) Subscribe(fn func(Event)) {
+	c.hooks.subscribers = append(c.hooks.subscribers, fn)
+}
+
+// Steps returns how many instructions were executed.
+func (c *cpu) Steps() int {
+	return c.steps
+}
+
+// readdata and writedata access data memory on behalf
+// of the current instruction and report watched words.
+func (c *cpu) readdata(addr int) uint64 {
+	val := c.data[addr]
+	if w, ok := c.hooks.watches[addr]; ok && w.Read {
+		c.hooks.events = append(c.hooks.events, Event{Kind: EventRead, IP: c.at, Addr: addr, Old: val})
+	}
+	return val
+}
+
+func (c *cpu) writedata(addr int, val uint64) {
+	old := c.data[addr]
+	c.remember(memData, addr, old)
+	c.data[addr] = val
+	c.tracewrite(addr, old, val)
+	if w, ok := c.hooks.watches[addr]; ok && w.Write {
+		c.hooks.events = append(c.hooks.events, Event{Kind: EventWrite, IP: c.at, Addr: addr, Old: old, New: val})
+	}
+}
+
+// checkbreaks adds events for breakpoints at the instruction
+// about to be executed, the ones for any address go first.
+func (c *cpu) checkbreaks() {
+	if len(c.hooks.breaks) == 0 {
+		return
+	}
+	bps := make([]Breakpoint, 0, len(c.hooks.breaks[AnyAddress])+len(c.hooks.breaks[c.ip]))
+	bps = append(bps, c.hooks.breaks[AnyAddress]...)
+	bps = append(bps, c.hooks.breaks[c.ip]...)
+	for _, bp := range bps {
+		if bp.Cond == nil || bp.Cond.holds(c) {
+			c.hooks.events = append(c.hooks.events, Event{Kind: EventBreak, IP: c.ip, Cond: bp.Cond})
+			// one event is enough for a stop at the address
+			break
+		}
+	}
+}
+
+// flushevents hands collected events to subscribers
+// and returns them as a *Break if there are any.
+func (c *cpu) flushevents() error {
+	if len(c.hooks.events) == 0 {
+		return nil
+	}
+	events := c.hooks.events
+	c.hooks.events = nil
+	for _, e := range events {
+		for _, fn := range c.hooks.subscribers {
+			fn(e)
+		}
+	}
+	return &Break{Events: events}
+}
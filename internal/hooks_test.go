@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func hookedcpu(t *testing.T, src string, data []uint64) (*cpu, *Image, *bytes.Buffer) {
+	t.Helper()
+
+	img, err := assembleString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.Data = data
+	c, err := FromImage(DefaultConfig(), img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	c.SetIO(strings.NewReader(""), out)
+	return c, img, out
+}
+
+// runs the program to its end collecting events of every stop
+func runevents(t *testing.T, c *cpu) []Event {
+	t.Helper()
+
+	events := make([]Event, 0)
+	for {
+		_, err := c.RunContext(context.Background(), 1000)
+		var brk *Break
+		if !errors.As(err, &brk) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			return events
+		}
+		events = append(events, brk.Events...)
+	}
+}
+
+func TestCpu_watchConvolution(t *testing.T) {
+	raw, err := os.ReadFile("../convolution.raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, img, out := hookedcpu(t, string(raw), []uint64{2, 1, 2, 2, 3, 4})
+	c.Watch(Watchpoint{Addr: 0, Write: true})
+
+	got := runevents(t, c)
+
+	stor := 6
+	if img.Code[stor] != STOR {
+		t.Fatalf("word %d is %d, expected stor", stor, img.Code[stor])
+	}
+	want := []Event{{Kind: EventWrite, IP: stor, Addr: 0, Old: 2, New: 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %+v, want %+v", got, want)
+	}
+	if out.String() != "11\n" {
+		t.Errorf("output = %q, want %q", out.String(), "11\n")
+	}
+}
+
+func TestCpu_watchRead(t *testing.T) {
+	c, _, _ := hookedcpu(t, "push 1 load push 1 load push 2 load term", []uint64{0, 5, 6})
+	c.Watch(Watchpoint{Addr: 1, Read: true})
+
+	got := runevents(t, c)
+	want := []Event{
+		{Kind: EventRead, IP: 2, Addr: 1, Old: 5},
+		{Kind: EventRead, IP: 5, Addr: 1, Old: 5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %+v, want %+v", got, want)
+	}
+
+	if !c.Unwatch(1) || c.Unwatch(1) {
+		t.Errorf("Unwatch() should report if the watchpoint was there")
+	}
+}
+
+func TestCpu_breakpoints(t *testing.T) {
+	src := `
+        push 3
+        stc
+loop:   cdec
+        cts
+        push &loop
+        swap
+        jnz
+        term`
+	cond := func(s string) *Condition {
+		cond, err := ParseCondition(strings.Fields(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &cond
+	}
+
+	tests := []struct {
+		name string
+		bp   Breakpoint
+		want []int
+	}{
+		{
+			name: "every pass",
+			bp:   Breakpoint{Addr: 3},
+			want: []int{3, 3, 3},
+		},
+		{
+			name: "counter",
+			bp:   Breakpoint{Addr: 3, Cond: cond("counter == 1")},
+			want: []int{3},
+		},
+		{
+			name: "stack top",
+			bp:   Breakpoint{Addr: 8, Cond: cond("top != 0")},
+			want: []int{8, 8},
+		},
+		{
+			name: "steps at any address",
+			bp:   Breakpoint{Addr: AnyAddress, Cond: cond("steps >= 0x10")},
+			want: []int{8, 9},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _, _ := hookedcpu(t, src, nil)
+			c.SetBreakpoint(tt.bp)
+
+			got := make([]int, 0)
+			for _, e := range runevents(t, c) {
+				if e.Kind != EventBreak {
+					t.Errorf("unexpected event %v", e)
+				}
+				got = append(got, e.IP)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("breakpoints hit at %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCpu_subscribe(t *testing.T) {
+	c, _, _ := hookedcpu(t, "push 7 push 0 stor push 0 load term", []uint64{0})
+	c.Watch(Watchpoint{Addr: 0, Read: true, Write: true})
+	c.SetBreakpoint(Breakpoint{Addr: 5})
+
+	got := make([]int, 0)
+	c.Subscribe(func(e Event) { got = append(got, e.Kind) })
+
+	steps, err := c.RunContext(context.Background(), 0)
+	var brk *Break
+	if !errors.As(err, &brk) || steps != 3 {
+		t.Fatalf("RunContext() = %d, %v, want a break after 3 steps", steps, err)
+	}
+	if want := []int{EventWrite, EventBreak}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+	if c.Steps() != 3 {
+		t.Errorf("Steps() = %d, want 3", c.Steps())
+	}
+}
+
+func TestParseCondition_errors(t *testing.T) {
+	tests := []string{
+		"counter ==",
+		"sp == 1",
+		"counter =~ 1",
+		"counter == x",
+	}
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			if _, err := ParseCondition(strings.Fields(tt)); err == nil {
+				t.Errorf("ParseCondition(%q) should fail", tt)
+			}
+		})
+	}
+}
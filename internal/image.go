@@ -0,0 +1,239 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ISAVersion is bumped whenever opcodes change meaning
+// or new ones are added to the instruction set. Version 1
+// is the first one, JNZ jumps to its target without offset.
+const ISAVersion = 1
+
+const (
+	imageMagic         = "SMVM"
+	imageFormatVersion = 1
+)
+
+const (
+	SectionCode = iota
+	SectionData
+)
+
+// Symbol is a label resolved to an address in one of the sections.
+type Symbol struct {
+	Name    string
+	Section int
+	Addr    uint64
+}
+
+// Image is an executable program together with
+// its initial data memory and symbol table.
+//
+// On disk it is stored as a little-endian header
+//
+//	magic "SMVM", format version (u16), isa version (u16),
+//	word width (u8), reserved (u8), entry point (u64),
+//	code words (u32), data words (u32), symbols (u32)
+//
+// followed by code and data words of the image word width and
+// the symbols, each one as section (u8), address (u64), name
+// length (u16) and name.
+type Image struct {
+	ISAVersion int
+	WordWidth  int
+	Entry      uint64
+	Code       []uint64
+	Data       []uint64
+	Symbols    []Symbol
+
+	// Debug is filled by the assembler and
+	// is not stored in the image file.
+	Debug *DebugInfo
+}
+
+var ErrNotImage = errors.New("not an image: bad magic")
+
+type imageHeader struct {
+	Magic    [4]byte
+	Format   uint16
+	ISA      uint16
+	Width    uint8
+	Reserved uint8
+	Entry    uint64
+	CodeLen  uint32
+	DataLen  uint32
+	SymLen   uint32
+}
+
+func (img *Image) Lookup(name string) (Symbol, bool) {
+	for _, sym := range img.Symbols {
+		if sym.Name == name {
+			return sym, true
+		}
+	}
+	return Symbol{}, false
+}
+
+// sortsymbols orders symbols by section and address.
+func sortsymbols(syms []Symbol) {
+	sort.Slice(syms, func(i, j int) bool {
+		if syms[i].Section != syms[j].Section {
+			return syms[i].Section < syms[j].Section
+		}
+		if syms[i].Addr != syms[j].Addr {
+			return syms[i].Addr < syms[j].Addr
+		}
+		return syms[i].Name < syms[j].Name
+	})
+}
+
+func WriteImage(w io.Writer, img *Image) error {
+	if !validwidth(img.WordWidth) {
+		return fmt.Errorf("unsupported word width: %d", img.WordWidth)
+	}
+
+	hdr := imageHeader{
+		Format:  imageFormatVersion,
+		ISA:     uint16(img.ISAVersion),
+		Width:   uint8(img.WordWidth),
+		Entry:   img.Entry,
+		CodeLen: uint32(len(img.Code)),
+		DataLen: uint32(len(img.Data)),
+		SymLen:  uint32(len(img.Symbols)),
+	}
+	copy(hdr.Magic[:], imageMagic)
+
+	if err := binary.Write(w, binary.LittleEndian, hdr); err != nil {
+		return err
+	}
+	if err := WriteWords(w, img.Code, img.WordWidth); err != nil {
+		return err
+	}
+	if err := WriteWords(w, img.Data, img.WordWidth); err != nil {
+		return err
+	}
+
+	for _, sym := range img.Symbols {
+		if len(sym.Name) > 0xFFFF {
+			return fmt.Errorf("symbol name too long: %d bytes", len(sym.Name))
+		}
+		fields := []interface{}{uint8(sym.Section), sym.Addr, uint16(len(sym.Name))}
+		for _, f := range fields {
+			if err := binary.Write(w, binary.LittleEndian, f); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(w, sym.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ReadImage(r io.Reader) (*Image, error) {
+	hdr := imageHeader{}
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("could not read image header: %w", err)
+	}
+	if string(hdr.Magic[:]) != imageMagic {
+		return nil, ErrNotImage
+	}
+	if hdr.Format != imageFormatVersion {
+		return nil, fmt.Errorf("unsupported image format version: %d", hdr.Format)
+	}
+	if int(hdr.ISA) > ISAVersion {
+		return nil, fmt.Errorf("image requires isa version %d, vm supports %d", hdr.ISA, ISAVersion)
+	}
+	width := int(hdr.Width)
+	if !validwidth(width) {
+		return nil, fmt.Errorf("unsupported word width: %d", width)
+	}
+
+	img := &Image{
+		ISAVersion: int(hdr.ISA),
+		WordWidth:  width,
+		Entry:      hdr.Entry,
+	}
+
+	var err error
+	if img.Code, err = readsection(r, int(hdr.CodeLen), width); err != nil {
+		return nil, fmt.Errorf("could not read code section: %w", err)
+	}
+	if img.Data, err = readsection(r, int(hdr.DataLen), width); err != nil {
+		return nil, fmt.Errorf("could not read data section: %w", err)
+	}
+
+	// counts are not trusted for preallocation, a corrupted
+	// one has to fail at the end of input instead
+	img.Symbols = make([]Symbol, 0)
+	for i := 0; i < int(hdr.SymLen); i++ {
+		var section uint8
+		var addr uint64
+		var namelen uint16
+		for _, f := range []interface{}{&section, &addr, &namelen} {
+			if err := binary.Read(r, binary.LittleEndian, f); err != nil {
+				return nil, fmt.Errorf("could not read symbol table: %w", err)
+			}
+		}
+		name := make([]byte, namelen)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, fmt.Errorf("could not read symbol table: %w", err)
+		}
+		img.Symbols = append(img.Symbols, Symbol{
+			Name:    string(name),
+			Section: int(section),
+			Addr:    addr,
+		})
+	}
+
+	return img, nil
+}
+
+// sectionChunk is how many words readsection allocates at once.
+const sectionChunk = 1 << 16
+
+// readsection reads words in bounded chunks, so a corrupted
+// length fails at the end of input instead of allocating it.
+func readsection(r io.Reader, words int, width int) ([]uint64, error) {
+	res := make([]uint64, 0)
+	for words > 0 {
+		n := words
+		if n > sectionChunk {
+			n = sectionChunk
+		}
+		raw := make([]byte, n*width/8)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return nil, err
+		}
+		chunk, err := ReadWords(bytes.NewReader(raw), width)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, chunk...)
+		words -= n
+	}
+	return res, nil
+}
+
+// LoadImage decodes an image, or a legacy file of raw
+// program words of the given width if magic is missing.
+func LoadImage(raw []byte, width int) (*Image, error) {
+	if bytes.HasPrefix(raw, []byte(imageMagic)) {
+		return ReadImage(bytes.NewReader(raw))
+	}
+
+	code, err := ReadWords(bytes.NewReader(raw), width)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{
+		ISAVersion: ISAVersion,
+		WordWidth:  width,
+		Code:       code,
+	}, nil
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImage_roundtrip(t *testing.T) {
+	img := &Image{
+		ISAVersion: ISAVersion,
+		WordWidth:  32,
+		Entry:      2,
+		Code:       []uint64{NOP, NOP, PUSH, 0x12345678, TERM},
+		Data:       []uint64{4, 1, 2, 3, 4},
+		Symbols: []Symbol{
+			{Name: "start", Section: SectionCode, Addr: 2},
+			{Name: "table", Section: SectionData, Addr: 0},
+		},
+	}
+
+	buf := bytes.Buffer{}
+	if err := WriteImage(&buf, img); err != nil {
+		t.Fatalf("WriteImage() error = %v", err)
+	}
+
+	got, err := ReadImage(&buf)
+	if err != nil {
+		t.Fatalf("ReadImage() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, img) {
+		t.Errorf("ReadImage() = %+v, want %+v", got, img)
+	}
+}
+
+func TestReadImage_errors(t *testing.T) {
+	valid := func() []byte {
+		buf := bytes.Buffer{}
+		img := &Image{ISAVersion: ISAVersion, WordWidth: 16, Code: []uint64{TERM}}
+		if err := WriteImage(&buf, img); err != nil {
+			t.Fatalf("WriteImage() error = %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name   string
+		mangle func(raw []byte) []byte
+	}{
+		{
+			name:   "bad magic",
+			mangle: func(raw []byte) []byte { raw[0] = 'X'; return raw },
+		},
+		{
+			name:   "unknown format version",
+			mangle: func(raw []byte) []byte { raw[4] = 0xFF; return raw },
+		},
+		{
+			name:   "newer isa version",
+			mangle: func(raw []byte) []byte { raw[6] = 0xFF; return raw },
+		},
+		{
+			name:   "truncated code section",
+			mangle: func(raw []byte) []byte { return raw[:len(raw)-1] },
+		},
+		{
+			name:   "huge code section",
+			mangle: func(raw []byte) []byte { raw[8] = 64; return hugecount(raw, 18) },
+		},
+		{
+			name:   "huge data section",
+			mangle: func(raw []byte) []byte { return hugecount(raw, 22) },
+		},
+		{
+			name:   "huge symbol table",
+			mangle: func(raw []byte) []byte { return hugecount(raw, 26) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadImage(bytes.NewReader(tt.mangle(valid()))); err == nil {
+				t.Error("ReadImage() should fail")
+			}
+		})
+	}
+}
+
+// hugecount overwrites a u32 count in a header with
+// a value that should not be trusted for allocation.
+func hugecount(raw []byte, at int) []byte {
+	binary.LittleEndian.PutUint32(raw[at:], 0xFFFFFFFF)
+	return raw
+}
+
+func TestLoadImage_legacy(t *testing.T) {
+	raw := []byte{byte(PUSH), 0, 7, 0, byte(TERM), 0}
+
+	img, err := LoadImage(raw, 16)
+	if err != nil {
+		t.Fatalf("LoadImage() error = %v", err)
+	}
+	if want := []uint64{PUSH, 7, TERM}; !reflect.DeepEqual(img.Code, want) {
+		t.Errorf("LoadImage() code = %v, want %v", img.Code, want)
+	}
+}
+
+func TestAssemble_entry(t *testing.T) {
+	src := "push 1 drop start: push 2 outnum term"
+	img, err := Assemble(*bufio.NewReader(strings.NewReader(src)), Options{Entry: "start"})
+	if err != nil {
+		t.Fatalf("Assemble() error = %v", err)
+	}
+
+	if sym, ok := img.Lookup("start"); !ok || sym.Addr != img.Entry {
+		t.Errorf("symbol start = %+v, entry = %d", sym, img.Entry)
+	}
+
+	out := bytes.Buffer{}
+	c, err := FromImage(DefaultConfig(), img)
+	if err != nil {
+		t.Fatalf("FromImage() error = %v", err)
+	}
+	c.SetIO(strings.NewReader(""), &out)
+	if err := c.Run(); err != nil {
+		t.Fatalf("cpu.Run() error = %v", err)
+	}
+	if out.String() != "2\n" {
+		t.Errorf("output = %q, want %q", out.String(), "2\n")
+	}
+}
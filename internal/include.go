@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// source is a named assembler source text.
+type source struct {
+	name string
+	text string
+}
+
+// includeframe is a file being lexed, abs is
+// used to tell if a file includes itself.
+type includeframe struct {
+	lexit *fsmlex
+	name  string
+	abs   string
+}
+
+// includer is a lexemiterator over several sources that
+// handles `.include "path"` by lexing the included file in
+// place of the directive. Relative paths are looked up next
+// to the including file first and then in search dirs.
+type includer struct {
+	rep     *reporter
+	dirs    []string
+	pending []source
+	stack   []*includeframe
+	files   []source
+	outbox  lexem
+	ready   bool
+}
+
+func newincluder(srcs []source, dirs []string, rep *reporter) *includer {
+	return &includer{
+		rep:     rep,
+		dirs:    dirs,
+		pending: srcs,
+	}
+}
+
+func (in *includer) hasnext() bool {
+	in.fill()
+	return in.ready
+}
+
+func (in *includer) next() lexem {
+	in.fill()
+	in.ready = false
+	return in.outbox
+}
+
+func (in *includer) push(src source) {
+	in.rep.addsource(src.name, src.text)
+	in.files = append(in.files, src)
+
+	rit := newfileruneiter(*bufio.NewReader(strings.NewReader(src.text)), src.name)
+	abs, err := filepath.Abs(src.name)
+	if err != nil {
+		abs = src.name
+	}
+	in.stack = append(in.stack, &includeframe{
+		lexit: newfsmlexwith(&rit, in.rep),
+		name:  src.name,
+		abs:   abs,
+	})
+}
+
+func (in *includer) fill() {
+	for !in.ready {
+		if len(in.stack) == 0 {
+			if len(in.pending) == 0 {
+				return
+			}
+			in.push(in.pending[0])
+			in.pending = in.pending[1:]
+			continue
+		}
+
+		top := in.stack[len(in.stack)-1]
+		if !top.lexit.hasnext() {
+			in.stack = in.stack[:len(in.stack)-1]
+			continue
+		}
+
+		lx := top.lexit.next()
+		if lx.typ == directive && strings.ToLower(lx.val) == ".include" {
+			in.include(lx, top)
+			continue
+		}
+
+		in.outbox = lx
+		in.ready = true
+	}
+}
+
+func (in *includer) include(lx lexem, top *includeframe) {
+	op := lexem{}
+	for top.lexit.hasnext() {
+		op = top.lexit.next()
+		if op.typ != comment {
+			break
+		}
+	}
+	if op.typ != strlit {
+		in.rep.errorf(lx.pos, "%s expects a quoted file name", lx.val)
+		return
+	}
+
+	path, err := strconv.Unquote(op.val)
+	if err != nil || path == "" {
+		in.rep.errorf(op.pos, "malformed file name %s", op.val)
+		return
+	}
+
+	name, text, ok := in.find(path, top.name)
+	if !ok {
+		in.rep.errorf(op.pos, "could not find included file '%s'", path)
+		return
+	}
+
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		abs = name
+	}
+	for i, frame := range in.stack {
+		if frame.abs != abs {
+			continue
+		}
+		chain := make([]string, 0, len(in.stack)-i+1)
+		for _, f := range in.stack[i:] {
+			chain = append(chain, f.name)
+		}
+		chain = append(chain, name)
+		in.rep.errorf(op.pos, "include cycle: %s", strings.Join(chain, " -> "))
+		return
+	}
+
+	in.push(source{name: name, text: text})
+}
+
+func (in *includer) find(path string, from string) (string, string, bool) {
+	candidates := []string{path}
+	if !filepath.IsAbs(path) {
+		candidates = []string{filepath.Join(filepath.Dir(from), path)}
+		for _, dir := range in.dirs {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+	}
+
+	for _, name := range candidates {
+		text, err := os.ReadFile(name)
+		if err == nil {
+			return name, string(text), true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writefiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestAssembleFiles_include(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		dirs  []string
+		main  []string
+		want  []uint64
+	}{
+		{
+			name: "file next to the including one",
+			files: map[string]string{
+				"main.sm": `.include "lib.sm"  push &end jmp end: term`,
+				"lib.sm":  "nop nop",
+			},
+			main: []string{"main.sm"},
+			want: []uint64{NOP, NOP, PUSH, 5, JMP, TERM},
+		},
+		{
+			name: "nested includes are relative to the including file",
+			files: map[string]string{
+				"main.sm":  `.include "lib/a.sm" term`,
+				"lib/a.sm": `add .include "b.sm"`,
+				"lib/b.sm": `sub`,
+			},
+			main: []string{"main.sm"},
+			want: []uint64{ADD, SUB, TERM},
+		},
+		{
+			name: "search dirs",
+			files: map[string]string{
+				"src/main.sm":   `.include "macros.sm" jzto end end: term`,
+				"inc/macros.sm": ".macro jzto target\n push &target swap jz\n.endm\n",
+			},
+			dirs: []string{"inc"},
+			main: []string{"src/main.sm"},
+			want: []uint64{PUSH, 4, SWAP, JZ, TERM},
+		},
+		{
+			name: "several input files",
+			files: map[string]string{
+				"a.sm": `push &sub call term`,
+				"b.sm": `sub: push 1 outnum ret`,
+			},
+			main: []string{"a.sm", "b.sm"},
+			want: []uint64{PUSH, 4, CALL, TERM, PUSH, 1, OUTNUM, RET},
+		},
+		{
+			name: "same file may be included twice",
+			files: map[string]string{
+				"main.sm": `.include "n.sm" .include "n.sm"`,
+				"n.sm":    `nop`,
+			},
+			main: []string{"main.sm"},
+			want: []uint64{NOP, NOP},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writefiles(t, tt.files)
+			opts := Options{}
+			for _, d := range tt.dirs {
+				opts.IncludeDirs = append(opts.IncludeDirs, filepath.Join(dir, d))
+			}
+			paths := make([]string, 0, len(tt.main))
+			for _, m := range tt.main {
+				paths = append(paths, filepath.Join(dir, m))
+			}
+
+			img, err := AssembleFiles(paths, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(img.Code, tt.want) {
+				t.Errorf("code = %v, want %v", img.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssembleFiles_includeDiagnostics(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantPos []string
+		wantMsg string
+	}{
+		{
+			name: "cycle",
+			files: map[string]string{
+				"main.sm": `.include "a.sm"`,
+				"a.sm":    `nop .include "b.sm"`,
+				"b.sm":    `.include "a.sm"`,
+			},
+			wantPos: []string{"b.sm:1:10"},
+			wantMsg: "include cycle: a.sm -> b.sm -> a.sm",
+		},
+		{
+			name: "missing file",
+			files: map[string]string{
+				"main.sm": "nop\n.include \"nope.sm\"",
+			},
+			wantPos: []string{"main.sm:2:10"},
+			wantMsg: "could not find included file 'nope.sm'",
+		},
+		{
+			name: "file name is not a string",
+			files: map[string]string{
+				"main.sm": ".include nope",
+			},
+			wantPos: []string{"main.sm:1:1"},
+		},
+		{
+			name: "errors point into included file",
+			files: map[string]string{
+				"main.sm": `.include "lib.sm"`,
+				"lib.sm":  "nop\n  frob",
+			},
+			wantPos: []string{"lib.sm:2:3"},
+			wantMsg: "  frob",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writefiles(t, tt.files)
+			_, err := AssembleFiles([]string{filepath.Join(dir, "main.sm")}, Options{})
+
+			var diags Diagnostics
+			if !errors.As(err, &diags) {
+				t.Fatalf("expected Diagnostics, got %v", err)
+			}
+			got := make([]string, 0, len(diags))
+			for _, d := range diags {
+				rel, _ := filepath.Rel(dir, d.Pos.File)
+				got = append(got, Pos{File: rel, Line: d.Pos.Line, Col: d.Pos.Col}.String())
+			}
+			if !reflect.DeepEqual(got, tt.wantPos) {
+				t.Errorf("diagnostic positions = %v, want %v (%v)", got, tt.wantPos, err)
+			}
+
+			msg := strings.ReplaceAll(err.Error(), dir+string(filepath.Separator), "")
+			if !strings.Contains(msg, tt.wantMsg) {
+				t.Errorf("error = %q, should contain %q", msg, tt.wantMsg)
+			}
+		})
+	}
+}
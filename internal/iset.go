@@ -30,6 +30,22 @@ const (
 	STC
 	TERM
 	MUL
+	CALL
+	RET
+	DIV
+	MOD
+	SHL
+	SHR
+	SAR
+	EQ
+	LT
+	GT
+	ULT
+	UGT
+	PUSHF
+	JC
+	JO
+	JN
 )
 
 func StoiSafe(name string) (int, error) {
@@ -92,6 +108,22 @@ var mapping = map[string]int{
 	"stc":    STC,
 	"term":   TERM,
 	"mul":    MUL,
+	"call":   CALL,
+	"ret":    RET,
+	"div":    DIV,
+	"mod":    MOD,
+	"shl":    SHL,
+	"shr":    SHR,
+	"sar":    SAR,
+	"eq":     EQ,
+	"lt":     LT,
+	"gt":     GT,
+	"ult":    ULT,
+	"ugt":    UGT,
+	"pushf":  PUSHF,
+	"jc":     JC,
+	"jo":     JO,
+	"jn":     JN,
 }
 
 func stoi(name string) (int, error) {
@@ -135,6 +167,22 @@ var rmapping = map[int]string{
 	STC:    "stc",
 	TERM:   "term",
 	MUL:    "mul",
+	CALL:   "call",
+	RET:    "ret",
+	DIV:    "div",
+	MOD:    "mod",
+	SHL:    "shl",
+	SHR:    "shr",
+	SAR:    "sar",
+	EQ:     "eq",
+	LT:     "lt",
+	GT:     "gt",
+	ULT:    "ult",
+	UGT:    "ugt",
+	PUSHF:  "pushf",
+	JC:     "jc",
+	JO:     "jo",
+	JN:     "jn",
 }
 
 func itos(val int) (string, error) {
@@ -6,4 +6,7 @@ const (
 	label
 	labelreference
 	comment
+	directive
+	strlit
+	expression
 )
@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// definition is a global symbol together
+// with the object it is defined in.
+type definition struct {
+	sym Symbol
+	obj string
+}
+
+// Link places code and data of the objects one after another,
+// resolves imported symbols to global symbols of other objects
+// and patches relocated words. Entry names a global label
+// execution starts at, program starts at address 0 if it is empty.
+// The image symbol table only holds global labels.
+func Link(objs []*Object, entry string) (*Image, error) {
+	if len(objs) == 0 {
+		return nil, fmt.Errorf("no objects to link")
+	}
+
+	img := &Image{
+		ISAVersion: objs[0].ISAVersion,
+		WordWidth:  objs[0].WordWidth,
+		Code:       make([]uint64, 0),
+		Data:       make([]uint64, 0),
+	}
+
+	// start of code and data of every object
+	bases := make([][2]uint64, len(objs))
+	globals := make(map[string]definition)
+	msgs := make([]string, 0)
+
+	for i, obj := range objs {
+		if obj.WordWidth != img.WordWidth {
+			return nil, fmt.Errorf("%s: word width %d does not match %d of %s",
+				obj.Name, obj.WordWidth, img.WordWidth, objs[0].Name)
+		}
+		if obj.ISAVersion > img.ISAVersion {
+			img.ISAVersion = obj.ISAVersion
+		}
+
+		bases[i] = [2]uint64{uint64(len(img.Code)), uint64(len(img.Data))}
+		img.Code = append(img.Code, obj.Code...)
+		img.Data = append(img.Data, obj.Data...)
+
+		for _, sym := range obj.Symbols {
+			if sym.Section != SectionCode && sym.Section != SectionData {
+				return nil, fmt.Errorf("%s: symbol '%s' in unknown section %d", obj.Name, sym.Name, sym.Section)
+			}
+			// local labels of different objects may share
+			// a name, so only globals get into the image
+			if !sym.Global {
+				continue
+			}
+			placed := sym.Symbol
+			placed.Addr += bases[i][sym.Section]
+			if prev, ok := globals[sym.Name]; ok {
+				msgs = append(msgs, fmt.Sprintf("%s: global label '%s' is already defined in %s",
+					obj.Name, sym.Name, prev.obj))
+				continue
+			}
+			globals[sym.Name] = definition{sym: placed, obj: obj.Name}
+			img.Symbols = append(img.Symbols, placed)
+		}
+	}
+
+	mask := wordmask(img.WordWidth)
+	for i, obj := range objs {
+		undefined := make(map[string]bool)
+		for _, rel := range obj.Relocs {
+			var sec []uint64
+			switch rel.Section {
+			case SectionCode:
+				sec = obj.Code
+			case SectionData:
+				sec = obj.Data
+			}
+			if rel.Addr >= uint64(len(sec)) {
+				return nil, fmt.Errorf("%s: relocation outside of section %d at %d", obj.Name, rel.Section, rel.Addr)
+			}
+
+			var addr uint64
+			switch {
+			case rel.Symbol != "":
+				def, ok := globals[rel.Symbol]
+				if !ok {
+					if !undefined[rel.Symbol] {
+						msgs = append(msgs, fmt.Sprintf("%s: undefined label '%s'", obj.Name, rel.Symbol))
+					}
+					undefined[rel.Symbol] = true
+					continue
+				}
+				addr = def.sym.Addr
+			case rel.Target == SectionCode || rel.Target == SectionData:
+				addr = bases[i][rel.Target]
+			default:
+				return nil, fmt.Errorf("%s: relocation to unknown section %d", obj.Name, rel.Target)
+			}
+
+			out := img.Code
+			if rel.Section == SectionData {
+				out = img.Data
+			}
+			at := bases[i][rel.Section] + rel.Addr
+			out[at] = (out[at] + addr) & mask
+		}
+	}
+
+	if len(msgs) != 0 {
+		return nil, errors.New(strings.Join(msgs, "\n"))
+	}
+
+	if entry != "" {
+		def, ok := globals[entry]
+		if !ok {
+			return nil, fmt.Errorf("entry label '%s' is not a global label", entry)
+		}
+		if def.sym.Section != SectionCode {
+			return nil, fmt.Errorf("entry label '%s' is not in code section", entry)
+		}
+		img.Entry = def.sym.Addr
+	}
+
+	sortsymbols(img.Symbols)
+	return img, nil
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func assembleObjects(t *testing.T, files map[string]string, names ...string) ([]*Object, []string) {
+	t.Helper()
+
+	dir := writefiles(t, files)
+	objs := make([]*Object, 0, len(names))
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		obj, err := AssembleObject([]string{path}, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj.Name = name
+		objs = append(objs, obj)
+		paths = append(paths, path)
+	}
+	return objs, paths
+}
+
+// linking objects gives the same program as
+// assembling all of the sources at once
+func TestLink_matchesAssembleFiles(t *testing.T) {
+	files := map[string]string{
+		"main.sm": `
+.extern print table
+.global main
+main:   push &table+1
+        load
+        push &print
+        call
+        push &done
+        jmp
+done:   term
+.data
+msg:    .word &done 1 2`,
+		"lib.sm": `
+.text
+.global print table
+print:  outnum
+        ret
+.data
+table:  .word 7 8 9 &table &print`,
+	}
+	objs, paths := assembleObjects(t, files, "main.sm", "lib.sm")
+
+	got, err := Link(objs, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := AssembleFiles(paths, Options{Entry: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got.Code, want.Code) {
+		t.Errorf("code = %v, want %v", got.Code, want.Code)
+	}
+	if !reflect.DeepEqual(got.Data, want.Data) {
+		t.Errorf("data = %v, want %v", got.Data, want.Data)
+	}
+	// only global labels are kept by the linker
+	wsyms := make([]Symbol, 0)
+	for _, sym := range want.Symbols {
+		if sym.Name == "main" || sym.Name == "print" || sym.Name == "table" {
+			wsyms = append(wsyms, sym)
+		}
+	}
+	if !reflect.DeepEqual(got.Symbols, wsyms) {
+		t.Errorf("symbols = %v, want %v", got.Symbols, wsyms)
+	}
+	if got.Entry != want.Entry {
+		t.Errorf("entry = %d, want %d", got.Entry, want.Entry)
+	}
+}
+
+func TestLink_localSymbols(t *testing.T) {
+	files := map[string]string{
+		"a.sm": ".global f\nf: loop: push &loop jmp",
+		"b.sm": "loop: push &loop jmp",
+	}
+	objs, _ := assembleObjects(t, files, "a.sm", "b.sm")
+
+	img, err := Link(objs, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Symbol{{Name: "f", Section: SectionCode, Addr: 0}}
+	if !reflect.DeepEqual(img.Symbols, want) {
+		t.Errorf("symbols = %v, want %v", img.Symbols, want)
+	}
+}
+
+func TestLink_errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		entry string
+		want  string
+	}{
+		{
+			name: "undefined symbol",
+			files: map[string]string{
+				"a.sm": ".extern f g\npush &f push &f push &g",
+				"b.sm": "nop",
+			},
+			want: "a.sm: undefined label 'f'\na.sm: undefined label 'g'",
+		},
+		{
+			name: "global defined twice",
+			files: map[string]string{
+				"a.sm": ".global f\nf: ret",
+				"b.sm": ".global f\nf: ret",
+			},
+			want: "b.sm: global label 'f' is already defined in a.sm",
+		},
+		{
+			name: "local labels are not visible to other objects",
+			files: map[string]string{
+				"a.sm": ".extern f\npush &f call",
+				"b.sm": "f: ret",
+			},
+			want: "a.sm: undefined label 'f'",
+		},
+		{
+			name: "entry is not global",
+			files: map[string]string{
+				"a.sm": "main: term",
+				"b.sm": "nop",
+			},
+			entry: "main",
+			want:  "entry label 'main' is not a global label",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, _ := assembleObjects(t, tt.files, "a.sm", "b.sm")
+			_, err := Link(objs, tt.entry)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Link() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLink_wordWidth(t *testing.T) {
+	objs := []*Object{
+		{Name: "a.o", WordWidth: 16},
+		{Name: "b.o", WordWidth: 32},
+	}
+	_, err := Link(objs, "")
+	if err == nil || !strings.Contains(err.Error(), "word width") {
+		t.Errorf("Link() error = %v, want word width mismatch", err)
+	}
+}
@@ -0,0 +1,211 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// listingWords is how many words are printed
+// on a single row of the listing.
+const listingWords = 4
+
+// SourceFile is an assembled file split into lines.
+type SourceFile struct {
+	Name  string
+	Lines []string
+}
+
+// DebugInfo ties an image back to the source it was assembled
+// from. Code and Data hold the position of every word, words
+// produced by a macro point at the macro invocation.
+type DebugInfo struct {
+	Files  []SourceFile
+	Code   []Pos
+	Data   []Pos
+	Labels map[string]Pos
+}
+
+func (c *compiler) debuginfo(srcs []source) *DebugInfo {
+	info := &DebugInfo{
+		Code:   make([]Pos, 0, len(c.origins[SectionCode])),
+		Data:   make([]Pos, 0, len(c.origins[SectionData])),
+		Labels: make(map[string]Pos),
+	}
+
+	seen := make(map[string]bool)
+	for _, src := range srcs {
+		if seen[src.name] {
+			continue
+		}
+		seen[src.name] = true
+		text := strings.ReplaceAll(src.text, "\r\n", "\n")
+		info.Files = append(info.Files, SourceFile{
+			Name:  src.name,
+			Lines: strings.Split(text, "\n"),
+		})
+	}
+
+	for _, pos := range c.origins[SectionCode] {
+		info.Code = append(info.Code, pos.anchor())
+	}
+	for _, pos := range c.origins[SectionData] {
+		info.Data = append(info.Data, pos.anchor())
+	}
+	for name, pos := range c.labelpos {
+		if !strings.Contains(name, "@") {
+			info.Labels[name] = pos.anchor()
+		}
+	}
+	return info
+}
+
+// listingword is a word emitted for a source line.
+type listingword struct {
+	section int
+	addr    int
+	val     uint64
+}
+
+// WriteListing prints every source line next to the addresses
+// and words assembled from it. Images without debug info,
+// e.g. the ones read from a file, can not be listed.
+func WriteListing(w io.Writer, img *Image) error {
+	if img.Debug == nil {
+		return fmt.Errorf("image has no debug info")
+	}
+
+	type lineKey struct {
+		file string
+		line int
+	}
+	words := make(map[lineKey][]listingword)
+	sections := []struct {
+		section int
+		words   []uint64
+		origins []Pos
+	}{
+		{SectionCode, img.Code, img.Debug.Code},
+		{SectionData, img.Data, img.Debug.Data},
+	}
+	for _, sec := range sections {
+		for i, pos := range sec.origins {
+			key := lineKey{pos.File, pos.Line}
+			words[key] = append(words[key], listingword{sec.section, i, sec.words[i]})
+		}
+	}
+
+	// lines with only a label still show its address
+	labels := make(map[lineKey]Symbol)
+	for _, sym := range img.Symbols {
+		if pos, ok := img.Debug.Labels[sym.Name]; ok {
+			labels[lineKey{pos.File, pos.Line}] = sym
+		}
+	}
+
+	digits := img.WordWidth / 4
+	blank := strings.Repeat(" ", 6+listingWords*(digits+1))
+
+	sb := strings.Builder{}
+	for _, file := range img.Debug.Files {
+		name := file.Name
+		if name == "" {
+			name = "<input>"
+		}
+		fmt.Fprintf(&sb, "; %s\n", name)
+
+		for i, line := range file.Lines {
+			key := lineKey{file.Name, i + 1}
+			emitted := words[key]
+			if sym, ok := labels[key]; ok && len(emitted) == 0 {
+				fmt.Fprintf(&sb, "%5d  %c:%04x%s  %s\n", i+1, sectionletter(sym.Section), sym.Addr, blank[6:], line)
+				continue
+			}
+			if len(emitted) == 0 {
+				fmt.Fprintf(&sb, "%5d  %s  %s\n", i+1, blank, line)
+				continue
+			}
+
+			for r, row := range listingrows(emitted) {
+				fmt.Fprintf(&sb, "%5d  %s", i+1, listingrow(row, digits))
+				if r == 0 {
+					fmt.Fprintf(&sb, "  %s", line)
+				}
+				sb.WriteString("\n")
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// listingrows splits words of a line into rows of at most
+// listingWords words, a new row is started if the words
+// are not contiguous.
+func listingrows(words []listingword) [][]listingword {
+	rows := make([][]listingword, 0)
+	start := 0
+	for i := 1; i <= len(words); i++ {
+		if i == len(words) || i-start == listingWords ||
+			words[i].section != words[i-1].section ||
+			words[i].addr != words[i-1].addr+1 {
+			rows = append(rows, words[start:i])
+			start = i
+		}
+	}
+	return rows
+}
+
+// listingrow prints address of the first word and the words.
+func listingrow(words []listingword, digits int) string {
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "%c:%04x", sectionletter(words[0].section), words[0].addr)
+	for _, word := range words {
+		fmt.Fprintf(&sb, " %0*x", digits, word.val)
+	}
+	sb.WriteString(strings.Repeat(" ", (listingWords-len(words))*(digits+1)))
+	return sb.String()
+}
+
+func sectionletter(section int) rune {
+	if section == SectionData {
+		return 'D'
+	}
+	return 'C'
+}
+
+var sectionnames = map[int]string{
+	SectionCode: "code",
+	SectionData: "data",
+}
+
+// WriteMap prints every symbol with its section and address
+// and, if debug info is present, where it was defined.
+func WriteMap(w io.Writer, img *Image) error {
+	syms := make([]Symbol, len(img.Symbols))
+	copy(syms, img.Symbols)
+	sortsymbols(syms)
+
+	width := len("name")
+	for _, sym := range syms {
+		if len(sym.Name) > width {
+			width = len(sym.Name)
+		}
+	}
+
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "%-7s %-8s %s\n", "section", "address", "name")
+	for _, sym := range syms {
+		fmt.Fprintf(&sb, "%-7s 0x%04x   %s", sectionnames[sym.Section], sym.Addr, sym.Name)
+		if img.Debug != nil {
+			if pos, ok := img.Debug.Labels[sym.Name]; ok {
+				fmt.Fprintf(&sb, "%*s  %s", width-len(sym.Name), "", pos)
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
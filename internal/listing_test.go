@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteListing(t *testing.T) {
+	src := ".macro twice x\n  x x\n.endm\nstart: push 1\n  twice nop\n.data\ntbl: .word 1 2 3 4 5"
+	img, err := assembleString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.Buffer{}
+	if err := WriteListing(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+
+	want := []string{
+		"; t.sm",
+		"    1                              .macro twice x",
+		"    2                                x x",
+		"    3                              .endm",
+		"    4  C:0000 000d 0001            start: push 1",
+		"    5  C:0002 0000 0000              twice nop",
+		"    6                              .data",
+		"    7  D:0000 0001 0002 0003 0004  tbl: .word 1 2 3 4 5",
+		"    7  D:0004 0005               ",
+		"",
+	}
+	for i := range want {
+		want[i] = strings.TrimRight(want[i], " ")
+	}
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " ")
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("listing =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestWriteListing_noDebugInfo(t *testing.T) {
+	err := WriteListing(&bytes.Buffer{}, &Image{WordWidth: 16})
+	if err == nil {
+		t.Errorf("expected an error for image without debug info")
+	}
+}
+
+func TestWriteMap(t *testing.T) {
+	img, err := assembleString("nop\nloop: push &loop jmp\n.data\nvalue: .word 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.Buffer{}
+	if err := WriteMap(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "section address  name\n" +
+		"code    0x0001   loop   t.sm:2:1\n" +
+		"data    0x0000   value  t.sm:4:1\n"
+	if buf.String() != want {
+		t.Errorf("map =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestListingrows(t *testing.T) {
+	words := []listingword{
+		{SectionCode, 0, 1}, {SectionCode, 1, 2}, {SectionCode, 2, 3},
+		{SectionCode, 3, 4}, {SectionCode, 4, 5},
+		{SectionCode, 9, 6},
+		{SectionData, 10, 7},
+	}
+	got := make([]int, 0)
+	for _, row := range listingrows(words) {
+		got = append(got, len(row))
+	}
+
+	want := []int{4, 1, 1, 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("row lengths = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,308 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxExpansionDepth stops macros that invoke themselves.
+const maxExpansionDepth = 64
+
+// macro is defined as
+//
+//	.macro name param1 param2
+//	        ...body...
+//	.endm
+//
+// parameters are listed on the same line as the name.
+type macro struct {
+	name   string
+	params []string
+	body   []lexem
+	locals map[string]bool
+}
+
+// expansion is one invocation of a macro, lexems
+// produced by it point back here through their Pos.
+type expansion struct {
+	macro  string
+	call   Pos
+	parent *expansion
+	depth  int
+}
+
+// macroexpander is a lexemiterator that consumes macro
+// definitions and replaces invocations with macro bodies.
+// Labels defined in a body get a unique `@n` suffix per
+// invocation, so a macro can be used more than once.
+type macroexpander struct {
+	lexit  lexemiterator
+	rep    *reporter
+	macros map[string]*macro
+	queue  []lexem
+	count  int
+	outbox lexem
+	ready  bool
+}
+
+func newmacroexpander(lexit lexemiterator, rep *reporter) *macroexpander {
+	return &macroexpander{
+		lexit:  lexit,
+		rep:    rep,
+		macros: make(map[string]*macro),
+	}
+}
+
+func (m *macroexpander) hasnext() bool {
+	m.fill()
+	return m.ready
+}
+
+func (m *macroexpander) next() lexem {
+	m.fill()
+	m.ready = false
+	return m.outbox
+}
+
+// raw returns expanded lexems first and then the ones from source.
+func (m *macroexpander) raw() (lexem, bool) {
+	if len(m.queue) != 0 {
+		lx := m.queue[0]
+		m.queue = m.queue[1:]
+		return lx, true
+	}
+	if m.lexit.hasnext() {
+		return m.lexit.next(), true
+	}
+	return lexem{}, false
+}
+
+func (m *macroexpander) unread(lxs ...lexem) {
+	m.queue = append(append([]lexem{}, lxs...), m.queue...)
+}
+
+func (m *macroexpander) fill() {
+	for !m.ready {
+		lx, ok := m.raw()
+		if !ok {
+			return
+		}
+
+		if lx.typ == directive {
+			switch strings.ToLower(lx.val) {
+			case ".macro":
+				m.define(lx)
+				continue
+			case ".endm":
+				m.rep.errorf(lx.pos, "'%s' without '.macro'", lx.val)
+				continue
+			}
+		}
+
+		if lx.typ == instruction {
+			if mac, ok := m.macros[lx.val]; ok {
+				m.expand(lx, mac)
+				continue
+			}
+		}
+
+		m.outbox = lx
+		m.ready = true
+	}
+}
+
+func (m *macroexpander) define(lx lexem) {
+	name, ok := m.raw()
+	for ok && name.typ == comment {
+		name, ok = m.raw()
+	}
+	if !ok || name.typ != instruction || name.pos.Line != lx.pos.Line {
+		m.rep.errorf(lx.pos, "%s expects a macro name", lx.val)
+		if ok {
+			m.unread(name)
+		}
+		return
+	}
+
+	mac := &macro{
+		name:   name.val,
+		locals: make(map[string]bool),
+	}
+
+	// parameters end with the line
+	for {
+		param, ok := m.raw()
+		if !ok {
+			break
+		}
+		if param.typ != instruction || param.pos.Line != lx.pos.Line || param.pos.File != lx.pos.File {
+			m.unread(param)
+			break
+		}
+		mac.params = append(mac.params, param.val)
+	}
+
+	closed := false
+	for {
+		body, ok := m.raw()
+		if !ok {
+			break
+		}
+		if body.typ == comment {
+			continue
+		}
+		if body.typ == directive && strings.ToLower(body.val) == ".endm" {
+			closed = true
+			break
+		}
+		if body.typ == directive && strings.ToLower(body.val) == ".macro" {
+			m.rep.errorf(body.pos, "macro definitions can not be nested")
+			continue
+		}
+		if body.typ == label {
+			mac.locals[body.val[:len(body.val)-1]] = true
+		}
+		mac.body = append(mac.body, body)
+	}
+
+	if !closed {
+		m.rep.errorf(lx.pos, "macro '%s' is missing '.endm'", mac.name)
+		return
+	}
+	if Sinst(strings.ToLower(mac.name)) {
+		m.rep.errorf(name.pos, "instruction '%s' can not be used as a macro name", mac.name)
+		return
+	}
+	if _, ok := m.macros[mac.name]; ok {
+		m.rep.errorf(name.pos, "macro '%s' redefined", mac.name)
+		return
+	}
+	m.macros[mac.name] = mac
+}
+
+// isargument tells if lexem can be passed to a macro.
+func isargument(lx lexem) bool {
+	switch lx.typ {
+	case instruction, integer, labelreference, expression, strlit:
+		return true
+	}
+	return false
+}
+
+func (m *macroexpander) expand(call lexem, mac *macro) {
+	// arguments end with the line, like parameters
+	args := make(map[string]lexem, len(mac.params))
+	for _, param := range mac.params {
+		arg, ok := m.raw()
+		for ok && arg.typ == comment {
+			arg, ok = m.raw()
+		}
+		if !ok || !isargument(arg) || arg.pos.Line != call.pos.Line || arg.pos.File != call.pos.File {
+			m.rep.errorf(call.pos, "macro '%s' expects %d arguments, got %d", mac.name, len(mac.params), len(args))
+			if ok {
+				m.unread(arg)
+			}
+			return
+		}
+		args[param] = arg
+	}
+
+	exp := &expansion{
+		macro:  mac.name,
+		call:   call.pos,
+		parent: call.pos.exp,
+	}
+	if exp.parent != nil {
+		exp.depth = exp.parent.depth + 1
+	}
+	if exp.depth >= maxExpansionDepth {
+		m.rep.errorf(call.pos, "macro '%s' is expanded more than %d levels deep", mac.name, maxExpansionDepth)
+		return
+	}
+
+	m.count++
+	suffix := fmt.Sprintf("@%d", m.count)
+
+	expanded := make([]lexem, 0, len(mac.body))
+	for _, lx := range mac.body {
+		lx.pos.exp = exp
+
+		switch lx.typ {
+		case instruction:
+			// the argument takes the place of the parameter, so a
+			// nested call finds it on the same line as the call
+			if arg, ok := args[lx.val]; ok {
+				arg.pos = lx.pos
+				lx = arg
+			}
+
+		case label:
+			name := lx.val[:len(lx.val)-1]
+			if mac.locals[name] {
+				lx.val = name + suffix + ":"
+			}
+
+		case labelreference:
+			name := lx.val[1:]
+			if mac.locals[name] {
+				lx.val = "&" + name + suffix
+			} else if arg, ok := args[name]; ok {
+				lx.val = labelarg(arg)
+				lx.typ = expression
+			}
+
+		case expression:
+			lx.val = substitute(lx.val, mac.locals, suffix, args)
+		}
+
+		expanded = append(expanded, lx)
+	}
+	m.unread(expanded...)
+}
+
+// labelarg turns an argument used as `&param` into a label
+// reference, both `name` and `&name` are accepted.
+func labelarg(arg lexem) string {
+	if strings.HasPrefix(arg.val, "&") {
+		return arg.val
+	}
+	return "&" + arg.val
+}
+
+// substitute renames local labels and replaces parameters
+// with parenthesized arguments inside of an expression.
+func substitute(src string, locals map[string]bool, suffix string, args map[string]lexem) string {
+	runes := []rune(src)
+	sb := strings.Builder{}
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		if !labch(r) {
+			sb.WriteRune(r)
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(runes) && (labch(runes[i]) || runes[i] == '@') {
+			i++
+		}
+		word := string(runes[start:i])
+
+		ref := start > 0 && runes[start-1] == '&'
+		arg, isparam := args[word]
+		switch {
+		case digit(r):
+			sb.WriteString(word)
+		case ref && locals[word]:
+			sb.WriteString(word + suffix)
+		case ref && isparam:
+			// & is already written
+			sb.WriteString(strings.TrimPrefix(arg.val, "&"))
+		case isparam:
+			sb.WriteString("(" + arg.val + ")")
+		default:
+			sb.WriteString(word)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,210 @@
+package internal
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssemble_macros(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []uint64
+	}{
+		{
+			name: "branch idiom",
+			src: `
+.macro jzto target
+        push &target
+        swap
+        jz
+.endm
+        jzto end
+        jzto &end
+end:    term`,
+			want: []uint64{PUSH, 8, SWAP, JZ, PUSH, 8, SWAP, JZ, TERM},
+		},
+		{
+			name: "local labels are unique per invocation",
+			src: `
+.macro spin
+loop:   push &loop
+        jmp
+.endm
+        spin
+        spin`,
+			want: []uint64{PUSH, 0, JMP, PUSH, 3, JMP},
+		},
+		{
+			name: "instructions and expressions as arguments",
+			src: `
+.equ LEN 4
+.macro binop op a b
+        push a
+        push b*2
+        op
+.endm
+        binop add 1 LEN+1`,
+			want: []uint64{PUSH, 1, PUSH, 10, ADD},
+		},
+		{
+			name: "nested invocation",
+			src: `
+.macro twice x
+        x x
+.endm
+.macro pushtwo v
+        push v push v
+.endm
+        twice nop
+        pushtwo 7`,
+			want: []uint64{NOP, NOP, PUSH, 7, PUSH, 7},
+		},
+		{
+			name: "parameters forwarded to nested invocations",
+			src: `
+.macro pushv v
+        push v
+.endm
+.macro twice v
+        pushv v
+        pushv v
+.endm
+.macro br cond target
+        push &target
+        swap
+        cond
+.endm
+.macro brz target
+        br jz target
+.endm
+        twice 7
+        brz end
+end:    term`,
+			want: []uint64{PUSH, 7, PUSH, 7, PUSH, 8, SWAP, JZ, TERM},
+		},
+		{
+			name: "label arguments inside expressions",
+			src: `
+.macro at base off
+        push &base+off
+.endm
+        at tbl 2
+.data
+tbl:    .zero 4`,
+			want: []uint64{PUSH, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := assembleString(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(img.Code, tt.want) {
+				t.Errorf("code = %v, want %v", img.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssemble_macroLocalSymbols(t *testing.T) {
+	img, err := assembleString(".macro m\nl: nop\n.endm\nm\nstart: m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Symbol{{Name: "start", Section: SectionCode, Addr: 1}}
+	if !reflect.DeepEqual(img.Symbols, want) {
+		t.Errorf("symbols = %v, want %v", img.Symbols, want)
+	}
+}
+
+func TestAssemble_macroDiagnostics(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       string
+		want      []string
+		wantNotes []string
+	}{
+		{
+			name:      "error in body points at definition and call",
+			src:       ".macro m\n  frob\n.endm\nnop\nm",
+			want:      []string{"t.sm:2:3"},
+			wantNotes: []string{"t.sm:5:1"},
+		},
+		{
+			name:      "nested invocation notes every call",
+			src:       ".macro a\n  frob\n.endm\n.macro b\n  a\n.endm\nb",
+			want:      []string{"t.sm:2:3"},
+			wantNotes: []string{"t.sm:5:3", "t.sm:7:1"},
+		},
+		{
+			name: "missing arguments",
+			src:  ".macro m x y\n  push x push y\n.endm\nm 1",
+			want: []string{"t.sm:4:1"},
+		},
+		{
+			name: "arguments on the next line",
+			src:  ".macro pushval v\n  push\n  v\n.endm\npushval\nterm",
+			want: []string{"t.sm:5:1"},
+		},
+		{
+			name: "missing endm",
+			src:  "nop\n.macro m\n  nop",
+			want: []string{"t.sm:2:1"},
+		},
+		{
+			name: "endm without macro",
+			src:  "nop .endm",
+			want: []string{"t.sm:1:5"},
+		},
+		{
+			name: "instruction as macro name",
+			src:  ".macro add\n.endm",
+			want: []string{"t.sm:1:8"},
+		},
+		{
+			name: "recursive macro",
+			src:  ".macro r\n  r\n.endm\nr",
+			want: []string{"t.sm:2:3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := assembleString(tt.src)
+			if got := diagpositions(t, err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diagnostic positions = %v, want %v (%v)", got, tt.want, err)
+			}
+
+			var diags Diagnostics
+			errors.As(err, &diags)
+			notes := make([]string, 0)
+			for _, n := range diags[0].Notes {
+				notes = append(notes, n.Pos.String())
+			}
+			if tt.wantNotes != nil && !reflect.DeepEqual(notes, tt.wantNotes) {
+				t.Errorf("note positions = %v, want %v", notes, tt.wantNotes)
+			}
+		})
+	}
+}
+
+func TestDiagnostic_Error_notes(t *testing.T) {
+	_, err := assembleString(".macro m\n  frob\n.endm\nm")
+
+	want := "t.sm:2:3: error: unknown instruction 'frob'\n" +
+		"  frob\n" +
+		"  ^\n" +
+		"t.sm:4:1: note: in expansion of macro 'm'\n" +
+		"m\n" +
+		"^"
+	if err == nil || err.Error() != want {
+		t.Errorf("error =\n%v\nwant\n%v", err, want)
+	}
+	if strings.Count(err.Error(), "error:") != 1 {
+		t.Errorf("should report a single error")
+	}
+}
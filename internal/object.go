@@ -0,0 +1,248 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	objectMagic         = "SMOB"
+	objectFormatVersion = 1
+)
+
+// symbol flags in object files
+const symGlobal = 1
+
+// Reloc is a word holding an address that is only known when
+// the object is linked. Start of the Target section, or address
+// of the imported Symbol if it is not empty, is added to the word.
+type Reloc struct {
+	Section int
+	Addr    uint64
+	Target  int
+	Symbol  string
+}
+
+// ObjectSymbol is a label defined in an object, global
+// labels may be used by other objects.
+type ObjectSymbol struct {
+	Symbol
+	Global bool
+}
+
+// Object is a relocatable piece of a program produced by the
+// assembler, addresses of its labels are offsets in their sections.
+//
+// On disk it is stored as a little-endian header
+//
+//	magic "SMOB", format version (u16), isa version (u16),
+//	word width (u8), reserved (u8), code words (u32),
+//	data words (u32), symbols (u32), relocations (u32)
+//
+// followed by code and data words of the object word width,
+// the symbols, each one as section (u8), flags (u8), address
+// (u64), name length (u16) and name, and the relocations, each
+// one as section (u8), target section (u8), address (u64),
+// symbol name length (u16) and symbol name.
+type Object struct {
+	ISAVersion int
+	WordWidth  int
+	Code       []uint64
+	Data       []uint64
+	Symbols    []ObjectSymbol
+	Relocs     []Reloc
+
+	// Name is used in link errors and
+	// is not stored in the object file.
+	Name string
+}
+
+var ErrNotObject = errors.New("not an object: bad magic")
+
+type objectHeader struct {
+	Magic    [4]byte
+	Format   uint16
+	ISA      uint16
+	Width    uint8
+	Reserved uint8
+	CodeLen  uint32
+	DataLen  uint32
+	SymLen   uint32
+	RelocLen uint32
+}
+
+// AssembleObject compiles files into a relocatable object. Labels
+// declared with .extern may be left undefined, they are resolved
+// by Link along with the ones declared .global in other objects.
+func AssembleObject(paths []string, opts Options) (*Object, error) {
+	if opts.Entry != "" {
+		return nil, fmt.Errorf("entry label is set when linking, not in an object")
+	}
+	srcs, err := readsources(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	comp, _, err := compilesources(srcs, opts, true)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &Object{
+		ISAVersion: ISAVersion,
+		WordWidth:  comp.width,
+		Code:       comp.out[SectionCode],
+		Data:       comp.out[SectionData],
+		Relocs:     comp.relocs,
+	}
+	if len(paths) > 0 {
+		obj.Name = paths[0]
+	}
+	for _, sym := range comp.symbols() {
+		_, global := comp.globals[sym.Name]
+		obj.Symbols = append(obj.Symbols, ObjectSymbol{Symbol: sym, Global: global})
+	}
+	return obj, nil
+}
+
+func WriteObject(w io.Writer, obj *Object) error {
+	if !validwidth(obj.WordWidth) {
+		return fmt.Errorf("unsupported word width: %d", obj.WordWidth)
+	}
+
+	hdr := objectHeader{
+		Format:   objectFormatVersion,
+		ISA:      uint16(obj.ISAVersion),
+		Width:    uint8(obj.WordWidth),
+		CodeLen:  uint32(len(obj.Code)),
+		DataLen:  uint32(len(obj.Data)),
+		SymLen:   uint32(len(obj.Symbols)),
+		RelocLen: uint32(len(obj.Relocs)),
+	}
+	copy(hdr.Magic[:], objectMagic)
+
+	if err := binary.Write(w, binary.LittleEndian, hdr); err != nil {
+		return err
+	}
+	if err := WriteWords(w, obj.Code, obj.WordWidth); err != nil {
+		return err
+	}
+	if err := WriteWords(w, obj.Data, obj.WordWidth); err != nil {
+		return err
+	}
+
+	for _, sym := range obj.Symbols {
+		flags := uint8(0)
+		if sym.Global {
+			flags |= symGlobal
+		}
+		fields := []interface{}{uint8(sym.Section), flags, sym.Addr}
+		if err := writefields(w, fields, sym.Name); err != nil {
+			return err
+		}
+	}
+	for _, rel := range obj.Relocs {
+		fields := []interface{}{uint8(rel.Section), uint8(rel.Target), rel.Addr}
+		if err := writefields(w, fields, rel.Symbol); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writefields writes fixed size fields followed by a name.
+func writefields(w io.Writer, fields []interface{}, name string) error {
+	if len(name) > 0xFFFF {
+		return fmt.Errorf("symbol name too long: %d bytes", len(name))
+	}
+	for _, f := range append(fields, uint16(len(name))) {
+		if err := binary.Write(w, binary.LittleEndian, f); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, name)
+	return err
+}
+
+func readfields(r io.Reader, fields []interface{}) (string, error) {
+	var namelen uint16
+	for _, f := range append(fields, &namelen) {
+		if err := binary.Read(r, binary.LittleEndian, f); err != nil {
+			return "", err
+		}
+	}
+	name := make([]byte, namelen)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return "", err
+	}
+	return string(name), nil
+}
+
+func ReadObject(r io.Reader) (*Object, error) {
+	hdr := objectHeader{}
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("could not read object header: %w", err)
+	}
+	if string(hdr.Magic[:]) != objectMagic {
+		return nil, ErrNotObject
+	}
+	if hdr.Format != objectFormatVersion {
+		return nil, fmt.Errorf("unsupported object format version: %d", hdr.Format)
+	}
+	if int(hdr.ISA) > ISAVersion {
+		return nil, fmt.Errorf("object requires isa version %d, assembler supports %d", hdr.ISA, ISAVersion)
+	}
+	width := int(hdr.Width)
+	if !validwidth(width) {
+		return nil, fmt.Errorf("unsupported word width: %d", width)
+	}
+
+	obj := &Object{
+		ISAVersion: int(hdr.ISA),
+		WordWidth:  width,
+	}
+
+	var err error
+	if obj.Code, err = readsection(r, int(hdr.CodeLen), width); err != nil {
+		return nil, fmt.Errorf("could not read code section: %w", err)
+	}
+	if obj.Data, err = readsection(r, int(hdr.DataLen), width); err != nil {
+		return nil, fmt.Errorf("could not read data section: %w", err)
+	}
+
+	// counts are not trusted for preallocation,
+	// like in ReadImage
+	obj.Symbols = make([]ObjectSymbol, 0)
+	for i := 0; i < int(hdr.SymLen); i++ {
+		var section, flags uint8
+		var addr uint64
+		name, err := readfields(r, []interface{}{&section, &flags, &addr})
+		if err != nil {
+			return nil, fmt.Errorf("could not read symbol table: %w", err)
+		}
+		obj.Symbols = append(obj.Symbols, ObjectSymbol{
+			Symbol: Symbol{Name: name, Section: int(section), Addr: addr},
+			Global: flags&symGlobal != 0,
+		})
+	}
+
+	obj.Relocs = make([]Reloc, 0)
+	for i := 0; i < int(hdr.RelocLen); i++ {
+		var section, target uint8
+		var addr uint64
+		name, err := readfields(r, []interface{}{&section, &target, &addr})
+		if err != nil {
+			return nil, fmt.Errorf("could not read relocations: %w", err)
+		}
+		obj.Relocs = append(obj.Relocs, Reloc{
+			Section: int(section),
+			Addr:    addr,
+			Target:  int(target),
+			Symbol:  name,
+		})
+	}
+
+	return obj, nil
+}
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestObject_roundtrip(t *testing.T) {
+	obj := &Object{
+		ISAVersion: ISAVersion,
+		WordWidth:  32,
+		Code:       []uint64{PUSH, 0, CALL, PUSH, 1, TERM},
+		Data:       []uint64{3, 0},
+		Symbols: []ObjectSymbol{
+			{Symbol: Symbol{Name: "main", Section: SectionCode, Addr: 0}, Global: true},
+			{Symbol: Symbol{Name: "table", Section: SectionData, Addr: 0}},
+		},
+		Relocs: []Reloc{
+			{Section: SectionCode, Addr: 1, Symbol: "print"},
+			{Section: SectionData, Addr: 1, Target: SectionData},
+		},
+	}
+
+	buf := bytes.Buffer{}
+	if err := WriteObject(&buf, obj); err != nil {
+		t.Fatalf("WriteObject() error = %v", err)
+	}
+
+	got, err := ReadObject(&buf)
+	if err != nil {
+		t.Fatalf("ReadObject() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, obj) {
+		t.Errorf("ReadObject() = %+v, want %+v", got, obj)
+	}
+}
+
+func TestReadObject_image(t *testing.T) {
+	buf := bytes.Buffer{}
+	if err := WriteImage(&buf, &Image{ISAVersion: ISAVersion, WordWidth: 16}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadObject(&buf); err != ErrNotObject {
+		t.Errorf("ReadObject() error = %v, want %v", err, ErrNotObject)
+	}
+}
+
+func TestReadObject_hugeCounts(t *testing.T) {
+	buf := bytes.Buffer{}
+	obj := &Object{ISAVersion: ISAVersion, WordWidth: 64, Code: []uint64{TERM}}
+	if err := WriteObject(&buf, obj); err != nil {
+		t.Fatal(err)
+	}
+
+	// code, data, symbol and relocation counts in the header
+	for _, at := range []int{10, 14, 18, 22} {
+		raw := hugecount(append([]byte{}, buf.Bytes()...), at)
+		if _, err := ReadObject(bytes.NewReader(raw)); err == nil {
+			t.Errorf("ReadObject() with a huge count at %d should fail", at)
+		}
+	}
+}
+
+func TestAssembleObject_relocs(t *testing.T) {
+	dir := writefiles(t, map[string]string{
+		"a.sm": `
+.extern print
+.global main
+main:   push 1
+        push &print
+        call
+        push &buf+2
+        push &end-&main
+        push &print+1
+end:    term
+.data
+buf:    .zero 4
+        .word &main &buf`,
+	})
+
+	obj, err := AssembleObject([]string{filepath.Join(dir, "a.sm")}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantCode := []uint64{PUSH, 1, PUSH, 0, CALL, PUSH, 2, PUSH, 11, PUSH, 1, TERM}
+	if !reflect.DeepEqual(obj.Code, wantCode) {
+		t.Errorf("code = %v, want %v", obj.Code, wantCode)
+	}
+	wantRelocs := []Reloc{
+		{Section: SectionCode, Addr: 3, Symbol: "print"},
+		{Section: SectionData, Addr: 4, Target: SectionCode},
+		{Section: SectionData, Addr: 5, Target: SectionData},
+		{Section: SectionCode, Addr: 6, Target: SectionData},
+		{Section: SectionCode, Addr: 10, Symbol: "print"},
+	}
+	if !reflect.DeepEqual(obj.Relocs, wantRelocs) {
+		t.Errorf("relocs = %+v, want %+v", obj.Relocs, wantRelocs)
+	}
+	wantSyms := []ObjectSymbol{
+		{Symbol: Symbol{Name: "main", Section: SectionCode, Addr: 0}, Global: true},
+		{Symbol: Symbol{Name: "end", Section: SectionCode, Addr: 11}},
+		{Symbol: Symbol{Name: "buf", Section: SectionData, Addr: 0}},
+	}
+	if !reflect.DeepEqual(obj.Symbols, wantSyms) {
+		t.Errorf("symbols = %+v, want %+v", obj.Symbols, wantSyms)
+	}
+}
+
+func TestAssembleObject_diagnostics(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "undefined label not declared extern",
+			src:  "push &print",
+			want: []string{"a.sm:1:6"},
+		},
+		{
+			name: "global label is not defined",
+			src:  ".global main\nterm",
+			want: []string{"a.sm:1:9"},
+		},
+		{
+			name: "product of addresses",
+			src:  ".extern x\npush &x*2",
+			want: []string{"a.sm:2:6"},
+		},
+		{
+			name: "zero count depends on address",
+			src:  ".data\nl: .zero &l+1",
+			want: []string{"a.sm:2:10"},
+		},
+		{
+			name: "global without names",
+			src:  ".global\nnop",
+			want: []string{"a.sm:1:1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writefiles(t, map[string]string{"a.sm": tt.src})
+			_, err := AssembleObject([]string{filepath.Join(dir, "a.sm")}, Options{})
+
+			got := diagpositions(t, err)
+			for i := range got {
+				got[i] = filepath.Base(got[i])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diagnostic positions = %v, want %v (%v)", got, tt.want, err)
+			}
+		})
+	}
+}
@@ -2,7 +2,6 @@ package internal
 
 import (
 	"bufio"
-	"unicode"
 )
 
 type runeiter struct {
@@ -10,12 +9,22 @@ type runeiter struct {
 	opened bool
 	closed bool
 	n      rune
+	p      Pos
 }
 
 func newruneiter(in bufio.Reader) runeiter {
 	ret := runeiter{in: in}
 	ret.walk()
 	ret.opened = true
+	ret.p = Pos{Line: 1, Col: 1}
+	return ret
+}
+
+// newfileruneiter is newruneiter which reports
+// positions inside of the named file.
+func newfileruneiter(in bufio.Reader, file string) runeiter {
+	ret := newruneiter(in)
+	ret.p.File = file
 	return ret
 }
 
@@ -31,10 +40,6 @@ func (ri *runeiter) walk() {
 		return
 	}
 
-	if ri.n == unicode.ReplacementChar {
-		panic("could not read rune: invalid code")
-	}
-
 	ri.n = n
 }
 
@@ -42,8 +47,19 @@ func (ri *runeiter) hasnext() bool {
 	return ri.opened && !ri.closed
 }
 
+// pos returns position of the rune next() is going to return.
+func (ri *runeiter) pos() Pos {
+	return ri.p
+}
+
 func (ri *runeiter) next() rune {
 	ret := ri.n
+	if ret == '\n' {
+		ri.p.Line++
+		ri.p.Col = 1
+	} else {
+		ri.p.Col++
+	}
 	ri.walk()
 	return ret
 }
@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const snapshotFormatVersion = 1
+
+// Snapshot is the complete state of a cpu, enough to resume the
+// program in another process. Memories and stacks are stored in
+// full, including words above the stack pointers. Attached io,
+// hooks, tracer and history are not part of the state.
+//
+// Its JSON form is stable: fields are only added in a new
+// format version, and Restore rejects versions it does not know.
+type Snapshot struct {
+	Version     int      `json:"version"`
+	ISAVersion  int      `json:"isa_version"`
+	Config      Config   `json:"config"`
+	Program     []uint64 `json:"program"`
+	Data        []uint64 `json:"data"`
+	Stack       []uint64 `json:"stack"`
+	ReturnStack []uint64 `json:"return_stack"`
+	SP          int      `json:"sp"`
+	RSP         int      `json:"rsp"`
+	IP          int      `json:"ip"`
+	Counter     uint64   `json:"counter"`
+	Flags       uint64   `json:"flags"`
+	Running     bool     `json:"running"`
+	Steps       int      `json:"steps"`
+}
+
+// Snapshot copies the state of the cpu.
+func (c *cpu) Snapshot() *Snapshot {
+	return &Snapshot{
+		Version:     snapshotFormatVersion,
+		ISAVersion:  ISAVersion,
+		Config:      c.cfg,
+		Program:     c.MemDump(),
+		Data:        c.DataDump(),
+		Stack:       c.StackDump(),
+		ReturnStack: c.ReturnStackDump(),
+		SP:          c.sp,
+		RSP:         c.rsp,
+		IP:          c.ip,
+		Counter:     c.cnt,
+		Flags:       c.flags,
+		Running:     c.running,
+		Steps:       c.steps,
+	}
+}
+
+// Restore replaces the state of the cpu with the snapshot,
+// including its geometry. Io, hooks and tracer are kept,
+// the history is cleared as it belongs to the old state.
+func (c *cpu) Restore(s *Snapshot) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	c.cfg = s.Config
+	c.initstack()
+	c.initrstack()
+	c.initmem()
+	c.initdata()
+	c.inithmap()
+	copy(c.program, s.Program)
+	copy(c.data, s.Data)
+	copy(c.stack, s.Stack)
+	copy(c.rstack, s.ReturnStack)
+	c.sp, c.rsp, c.ip = s.SP, s.RSP, s.IP
+	c.cnt, c.flags = s.Counter, s.Flags
+	c.running = s.Running
+	c.steps = s.Steps
+	c.trace = nil
+	c.hooks.events = nil
+	if c.history != nil {
+		c.EnableHistory(c.history.limit)
+	}
+	return nil
+}
+
+// FromSnapshot creates a cpu in the state of the snapshot.
+func FromSnapshot(s *Snapshot) (*cpu, error) {
+	ret := &cpu{}
+	ret.initio()
+	if err := ret.Restore(s); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s *Snapshot) validate() error {
+	if s.Version != snapshotFormatVersion {
+		return fmt.Errorf("unsupported snapshot format version: %d", s.Version)
+	}
+	if s.ISAVersion > ISAVersion {
+		return fmt.Errorf("snapshot requires isa version %d, vm supports %d", s.ISAVersion, ISAVersion)
+	}
+	cfg := s.Config
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	mems := []struct {
+		name  string
+		words []uint64
+		size  int
+	}{
+		{"program", s.Program, cfg.ProgramWords},
+		{"data", s.Data, cfg.DataWords},
+		{"stack", s.Stack, cfg.StackDepth},
+		{"return stack", s.ReturnStack, cfg.ReturnDepth},
+	}
+	mask := wordmask(cfg.width())
+	for _, m := range mems {
+		if len(m.words) > m.size {
+			return fmt.Errorf("%s of %d words does not fit into %d words", m.name, len(m.words), m.size)
+		}
+		for i, w := range m.words {
+			if w&^mask != 0 {
+				return fmt.Errorf("%s word %#04x does not fit into %d bits", m.name, i, cfg.width())
+			}
+		}
+	}
+
+	if s.SP < -1 || s.SP >= cfg.StackDepth {
+		return fmt.Errorf("stack pointer %d is outside of the stack", s.SP)
+	}
+	if s.RSP < -1 || s.RSP >= cfg.ReturnDepth {
+		return fmt.Errorf("return stack pointer %d is outside of the return stack", s.RSP)
+	}
+	if s.Counter&^mask != 0 || s.Flags&^mask != 0 {
+		return fmt.Errorf("registers do not fit into %d bits", cfg.width())
+	}
+	if s.Steps < 0 {
+		return fmt.Errorf("negative step count %d", s.Steps)
+	}
+	return nil
+}
+
+// WriteSnapshot writes the snapshot as a line of JSON.
+func WriteSnapshot(w io.Writer, s *Snapshot) error {
+	return json.NewEncoder(w).Encode(s)
+}
+
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	s := &Snapshot{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(s); err != nil {
+		return nil, fmt.Errorf("could not read snapshot: %w", err)
+	}
+	return s, nil
+}
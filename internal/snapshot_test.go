@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCpu_snapshotResume(t *testing.T) {
+	raw, err := os.ReadFile("../convolution.raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []uint64{2, 1, 2, 2, 3, 4}
+
+	whole, _, wholeout := hookedcpu(t, string(raw), data)
+	if err := whole.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, split := range []int{1, 10, 25} {
+		first, _, out := hookedcpu(t, string(raw), data)
+		if _, err := first.RunContext(context.Background(), split); !errors.Is(err, ErrStepLimit) {
+			t.Fatalf("RunContext(%d) error = %v, want %v", split, err, ErrStepLimit)
+		}
+
+		buf := bytes.Buffer{}
+		if err := WriteSnapshot(&buf, first.Snapshot()); err != nil {
+			t.Fatal(err)
+		}
+		snap, err := ReadSnapshot(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := FromSnapshot(snap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second.SetIO(strings.NewReader(""), out)
+		if err := second.Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(second.Snapshot(), whole.Snapshot()) {
+			t.Errorf("resumed after %d steps:\n%+v\nwant\n%+v", split, second.Snapshot(), whole.Snapshot())
+		}
+		if out.String() != wholeout.String() {
+			t.Errorf("resumed after %d steps printed %q, want %q", split, out.String(), wholeout.String())
+		}
+	}
+}
+
+func TestCpu_snapshotJSON(t *testing.T) {
+	cfg := Config{ProgramWords: 4, DataWords: 1, StackDepth: 2, ReturnDepth: 1, WordWidth: 16}
+	c, err := WithConfig(cfg, []uint64{PUSH, 7, CINC, TERM}, []uint64{5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.tick(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := bytes.Buffer{}
+	if err := WriteSnapshot(&buf, c.Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"version":1,"isa_version":1,` +
+		`"config":{"program_words":4,"data_words":1,"stack_depth":2,"return_depth":1,"word_width":16},` +
+		`"program":[13,7,21,25],"data":[5],"stack":[7,0],"return_stack":[0],` +
+		`"sp":0,"rsp":-1,"ip":3,"counter":1,"flags":0,"running":true,"steps":2}` + "\n"
+	if buf.String() != want {
+		t.Errorf("WriteSnapshot() =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestCpu_restore(t *testing.T) {
+	valid := func() *Snapshot {
+		c, err := WithConfig(Config{ProgramWords: 2, DataWords: 1, StackDepth: 2, ReturnDepth: 1}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.Snapshot()
+	}
+
+	tests := []struct {
+		name   string
+		modify func(s *Snapshot)
+		err    string
+	}{
+		{"valid", func(s *Snapshot) {}, ""},
+		{"short memories", func(s *Snapshot) { s.Program, s.Data = []uint64{TERM}, nil }, ""},
+		{"version", func(s *Snapshot) { s.Version = 2 }, "unsupported snapshot format version: 2"},
+		{"isa", func(s *Snapshot) { s.ISAVersion = ISAVersion + 1 }, "snapshot requires isa version"},
+		{"config", func(s *Snapshot) { s.Config.StackDepth = 0 }, "stack must hold at least one word"},
+		{"long program", func(s *Snapshot) { s.Program = make([]uint64, 3) }, "program of 3 words does not fit into 2 words"},
+		{"wide word", func(s *Snapshot) { s.Stack[1] = 1 << 16 }, "stack word 0x0001 does not fit into 16 bits"},
+		{"sp", func(s *Snapshot) { s.SP = 2 }, "stack pointer 2 is outside of the stack"},
+		{"rsp", func(s *Snapshot) { s.RSP = -2 }, "return stack pointer -2 is outside of the return stack"},
+		{"counter", func(s *Snapshot) { s.Counter = 1 << 16 }, "registers do not fit into 16 bits"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid()
+			tt.modify(s)
+			_, err := FromSnapshot(s)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("FromSnapshot() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("FromSnapshot() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCpu_restoreKeepsIO(t *testing.T) {
+	c, _, out := hookedcpu(t, "push 42\noutnum\nterm", nil)
+	snap := c.Snapshot()
+	c.EnableHistory(0)
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Restore(snap); err != nil {
+		t.Fatal(err)
+	}
+	if c.HistoryLen() != 0 {
+		t.Errorf("HistoryLen() after Restore = %d, want 0", c.HistoryLen())
+	}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "42\n42\n" {
+		t.Errorf("output = %q, want %q", out.String(), "42\n42\n")
+	}
+}
+
+func TestReadSnapshot(t *testing.T) {
+	if _, err := ReadSnapshot(strings.NewReader(`{"version":1,"registers":[]}`)); err == nil {
+		t.Errorf("ReadSnapshot() should reject unknown fields")
+	}
+}
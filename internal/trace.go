@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Tracer is told about every instruction the cpu executes,
+// including the one that faulted.
+type Tracer interface {
+	Trace(rec TraceRecord)
+}
+
+// DataWrite is a data memory word changed by an instruction.
+type DataWrite struct {
+	Addr int    `json:"addr"`
+	Old  uint64 `json:"old"`
+	New  uint64 `json:"new"`
+}
+
+// TraceRecord describes an executed instruction. Step counts
+// instructions from 1, Op is empty for unknown opcodes and
+// Operand is only set for PUSH. Stacks are listed from the
+// bottom, Counter is the counter register after the instruction.
+type TraceRecord struct {
+	Step        int         `json:"step"`
+	IP          int         `json:"ip"`
+	Opcode      uint64      `json:"opcode"`
+	Op          string      `json:"op,omitempty"`
+	Operand     *uint64     `json:"operand,omitempty"`
+	StackBefore []uint64    `json:"stack_before"`
+	StackAfter  []uint64    `json:"stack_after"`
+	Counter     uint64      `json:"counter"`
+	Writes      []DataWrite `json:"writes,omitempty"`
+	Fault       string      `json:"fault,omitempty"`
+}
+
+// SetTracer attaches a tracer, nil turns tracing off.
+func (c *cpu) SetTracer(t Tracer) {
+	c.tracer = t
+}
+
+// stackcopy returns stack contents from the bottom.
+func (c *cpu) stackcopy() []uint64 {
+	res := make([]uint64, c.sp+1)
+	copy(res, c.stack)
+	return res
+}
+
+// entrystack returns the stack as it was before the current
+// instruction, undoing the words the instruction overwrote.
+func (c *cpu) entrystack() []uint64 {
+	res := make([]uint64, len(c.stack))
+	copy(res, c.stack)
+	for i := len(c.undo) - 1; i >= 0; i-- {
+		res[c.undo[i].addr] = c.undo[i].old
+	}
+	return res[:c.entrysp+1]
+}
+
+// tracestart begins a record for the instruction at c.at.
+func (c *cpu) tracestart() {
+	if c.tracer == nil {
+		return
+	}
+	rec := TraceRecord{
+		Step: c.steps + 1,
+		IP:   c.at,
+	}
+	if c.at >= 0 && c.at < len(c.program) {
+		rec.Opcode = c.program[c.at]
+		if name, err := itos(int(rec.Opcode)); err == nil {
+			rec.Op = name
+		}
+		if rec.Opcode == PUSH && c.at+1 < len(c.program) {
+			operand := c.program[c.at+1]
+			rec.Operand = &operand
+		}
+	}
+	c.trace = &rec
+}
+
+func (c *cpu) tracewrite(addr int, old uint64, val uint64) {
+	if c.trace != nil {
+		c.trace.Writes = append(c.trace.Writes, DataWrite{Addr: addr, Old: old, New: val})
+	}
+}
+
+// traceend completes the record and hands it to the tracer.
+func (c *cpu) traceend(fault error) {
+	if c.trace == nil {
+		return
+	}
+	rec := c.trace
+	c.trace = nil
+
+	rec.StackBefore = c.entrystack()
+	rec.StackAfter = c.stackcopy()
+	rec.Counter = c.cnt
+	if fault != nil {
+		rec.Fault = fault.Error()
+	}
+	c.tracer.Trace(*rec)
+}
+
+// JSONTracer writes every record as a line of JSON.
+type JSONTracer struct {
+	enc *json.Encoder
+	err error
+}
+
+func NewJSONTracer(w io.Writer) *JSONTracer {
+	return &JSONTracer{enc: json.NewEncoder(w)}
+}
+
+func (t *JSONTracer) Trace(rec TraceRecord) {
+	if t.err != nil {
+		return
+	}
+	if err := t.enc.Encode(rec); err != nil {
+		t.err = fmt.Errorf("could not write trace: %w", err)
+	}
+}
+
+// Err returns the first error writing the trace.
+func (t *JSONTracer) Err() error {
+	return t.err
+}
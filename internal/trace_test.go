@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type recorder []TraceRecord
+
+func (r *recorder) Trace(rec TraceRecord) {
+	*r = append(*r, rec)
+}
+
+func u64(v uint64) *uint64 {
+	return &v
+}
+
+func TestCpu_trace(t *testing.T) {
+	c := WithMemProg([]uint64{PUSH, 5, PUSH, 0, STOR, CINC, DROP}, []uint64{9})
+	rec := recorder{}
+	c.SetTracer(&rec)
+
+	err := c.Run()
+	if err == nil {
+		t.Fatalf("expected stack underflow")
+	}
+
+	want := recorder{
+		{Step: 1, IP: 0, Opcode: PUSH, Op: "push", Operand: u64(5),
+			StackBefore: []uint64{}, StackAfter: []uint64{5}},
+		{Step: 2, IP: 2, Opcode: PUSH, Op: "push", Operand: u64(0),
+			StackBefore: []uint64{5}, StackAfter: []uint64{5, 0}},
+		{Step: 3, IP: 4, Opcode: STOR, Op: "stor",
+			StackBefore: []uint64{5, 0}, StackAfter: []uint64{},
+			Writes: []DataWrite{{Addr: 0, Old: 9, New: 5}}},
+		{Step: 4, IP: 5, Opcode: CINC, Op: "cinc",
+			StackBefore: []uint64{}, StackAfter: []uint64{}, Counter: 1},
+		{Step: 5, IP: 6, Opcode: DROP, Op: "drop",
+			StackBefore: []uint64{}, StackAfter: []uint64{}, Counter: 1,
+			Fault: err.Error()},
+	}
+	if !reflect.DeepEqual(rec, want) {
+		t.Errorf("trace =\n%+v\nwant\n%+v", rec, want)
+	}
+}
+
+func TestCpu_traceUnknownOpcode(t *testing.T) {
+	c := WithMemProg([]uint64{0x7F}, nil)
+	rec := recorder{}
+	c.SetTracer(&rec)
+	_ = c.Run()
+
+	if len(rec) != 1 || rec[0].Op != "" || rec[0].Opcode != 0x7F || rec[0].Fault == "" {
+		t.Errorf("trace = %+v, want a faulted record of opcode 0x7f", rec)
+	}
+}
+
+func TestJSONTracer(t *testing.T) {
+	c := WithMemProg([]uint64{PUSH, 1, TERM}, nil)
+	buf := bytes.Buffer{}
+	tracer := NewJSONTracer(&buf)
+	c.SetTracer(tracer)
+
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracer.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"step":1,"ip":0,"opcode":13,"op":"push","operand":1,"stack_before":[],"stack_after":[1],"counter":0}` + "\n" +
+		`{"step":2,"ip":2,"opcode":25,"op":"term","stack_before":[1],"stack_after":[1],"counter":0}` + "\n"
+	if buf.String() != want {
+		t.Errorf("trace =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	// every line is a record on its own
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		rec := TraceRecord{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Errorf("could not decode %q: %v", line, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const defaultWordWidth = 16
+
+// width returns the word width in bits, zero
+// value of the config means default width.
+func (cfg Config) width() int {
+	if cfg.WordWidth == 0 {
+		return defaultWordWidth
+	}
+	return cfg.WordWidth
+}
+
+func validwidth(width int) bool {
+	return width == 16 || width == 32 || width == 64
+}
+
+func wordmask(width int) uint64 {
+	return 1<<uint(width) - 1
+}
+
+// sext sign-extends word of the given width to int64.
+func sext(x uint64, width int) int64 {
+	shift := uint(64 - width)
+	return int64(x<<shift) >> shift
+}
+
+func (c *cpu) mask() uint64 {
+	return wordmask(c.cfg.width())
+}
+
+func (c *cpu) signbit() uint64 {
+	return 1 << uint(c.cfg.width()-1)
+}
+
+func (c *cpu) signed(x uint64) int64 {
+	return sext(x, c.cfg.width())
+}
+
+// WriteWords writes words as little-endian
+// values of width bits each.
+func WriteWords(w io.Writer, words []uint64, width int) error {
+	if !validwidth(width) {
+		return fmt.Errorf("unsupported word width: %d", width)
+	}
+
+	size := width / 8
+	buf := make([]byte, 8)
+	for _, word := range words {
+		binary.LittleEndian.PutUint64(buf, word)
+		if _, err := w.Write(buf[:size]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadWords reads little-endian words of width
+// bits each until the end of r.
+func ReadWords(r io.Reader, width int) ([]uint64, error) {
+	if !validwidth(width) {
+		return nil, fmt.Errorf("unsupported word width: %d", width)
+	}
+
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	size := width / 8
+	if len(raw)%size != 0 {
+		return nil, fmt.Errorf("%d bytes is not a whole number of %d-bit words", len(raw), width)
+	}
+
+	words := make([]uint64, len(raw)/size)
+	buf := make([]byte, 8)
+	for i := range words {
+		copy(buf, raw[i*size:(i+1)*size])
+		words[i] = binary.LittleEndian.Uint64(buf)
+	}
+	return words, nil
+}
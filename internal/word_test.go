@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestWords_roundtrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		width int
+		words []uint64
+		size  int
+	}{
+		{name: "16 bit", width: 16, words: []uint64{1, 0xFFFF}, size: 4},
+		{name: "32 bit", width: 32, words: []uint64{1, 0xFFFFFFFF}, size: 8},
+		{name: "64 bit", width: 64, words: []uint64{1, 0xFFFFFFFFFFFFFFFF}, size: 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			if err := WriteWords(&buf, tt.words, tt.width); err != nil {
+				t.Fatalf("WriteWords() error = %v", err)
+			}
+			if buf.Len() != tt.size {
+				t.Errorf("WriteWords() wrote %d bytes, want %d", buf.Len(), tt.size)
+			}
+
+			got, err := ReadWords(&buf, tt.width)
+			if err != nil {
+				t.Fatalf("ReadWords() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.words) {
+				t.Errorf("ReadWords() = %v, want %v", got, tt.words)
+			}
+		})
+	}
+}
+
+func TestReadWords_truncated(t *testing.T) {
+	if _, err := ReadWords(bytes.NewReader([]byte{1, 2, 3}), 16); err == nil {
+		t.Error("ReadWords() should fail on a partial word")
+	}
+}
+
+func TestCpu_width(t *testing.T) {
+	tests := []struct {
+		name  string
+		width int
+		op    func(c *cpu) error
+		stack []uint64
+		want  uint64
+		flags uint64
+	}{
+		{
+			name:  "32 bit add should carry out of 32 bits",
+			width: 32,
+			op:    (*cpu).iadd,
+			stack: []uint64{0xFFFFFFFF, 2},
+			want:  1,
+			flags: FlagCarry,
+		},
+		{
+			name:  "32 bit add should not carry at 16 bits",
+			width: 32,
+			op:    (*cpu).iadd,
+			stack: []uint64{0xFFFF, 1},
+			want:  0x10000,
+		},
+		{
+			name:  "64 bit mul should set carry on unsigned overflow",
+			width: 64,
+			op:    (*cpu).imul,
+			stack: []uint64{1 << 63, 2},
+			want:  0,
+			flags: FlagZero | FlagCarry | FlagOverflow,
+		},
+		{
+			name:  "32 bit sar should keep the sign bit",
+			width: 32,
+			op:    (*cpu).isar,
+			stack: []uint64{0x80000000, 31},
+			want:  0xFFFFFFFF,
+			flags: FlagNegative,
+		},
+		{
+			name:  "64 bit compl should negate",
+			width: 64,
+			op:    (*cpu).icomp,
+			stack: []uint64{1},
+			want:  0xFFFFFFFFFFFFFFFF,
+			flags: FlagCarry | FlagNegative,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cpu{
+				cfg:   Config{WordWidth: tt.width},
+				sp:    len(tt.stack) - 1,
+				stack: make([]uint64, StackLimit),
+			}
+			copy(c.stack, tt.stack)
+
+			if err := tt.op(&c); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c.stack[c.sp] != tt.want {
+				t.Errorf("result = %#x, want %#x", c.stack[c.sp], tt.want)
+			}
+			if c.flags != tt.flags {
+				t.Errorf("flags = %s, want %s", formatFlags(c.flags), formatFlags(tt.flags))
+			}
+		})
+	}
+}